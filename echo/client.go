@@ -1,18 +1,127 @@
 package main
 
 import (
+	"bytes"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/exec"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"github.com/quyenhl16/go-dspt/echo/pcap"
 )
 
+// monitorWatch tracks when the monitoring server last sent an ECHO-REQUEST,
+// so the client can tell when nobody is watching it anymore.
+type monitorWatch struct {
+	timeout    time.Duration
+	hook       string // Shell command run on every lost/restored transition
+	statusFile string // File rewritten with the current state on every transition
+
+	mu          sync.Mutex
+	lastRequest time.Time
+	lost        bool
+}
+
+func newMonitorWatch(timeout time.Duration, hook, statusFile string) *monitorWatch {
+	return &monitorWatch{
+		timeout:     timeout,
+		hook:        hook,
+		statusFile:  statusFile,
+		lastRequest: time.Now(), // Give the server a full timeout to show up
+	}
+}
+
+// seen records an ECHO-REQUEST and reports a restored monitor if it was lost.
+func (w *monitorWatch) seen(from *net.UDPAddr) {
+	w.mu.Lock()
+	w.lastRequest = time.Now()
+	wasLost := w.lost
+	w.lost = false
+	w.mu.Unlock()
+
+	if wasLost {
+		log.Printf("Monitor restored: echo request from %s", from)
+		w.transition("restored", from.String())
+	}
+}
+
+// minMonitorCheck bounds how often the watch checks for silence, so a tiny
+// timeout does not spin (or panic on a zero ticker interval).
+const minMonitorCheck = 10 * time.Millisecond
+
+// run checks for silence until stop is closed.
+func (w *monitorWatch) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(max(w.timeout/4, minMonitorCheck))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			silence := time.Since(w.lastRequest)
+			becameLost := !w.lost && silence > w.timeout
+			if becameLost {
+				w.lost = true
+			}
+			w.mu.Unlock()
+
+			if becameLost {
+				log.Printf("Monitor lost: no echo request for %s", silence.Round(time.Second))
+				w.transition("lost", "")
+			}
+		}
+	}
+}
+
+// transition writes the status file and runs the hook for a new state.
+func (w *monitorWatch) transition(state, monitor string) {
+	w.mu.Lock()
+	last := w.lastRequest
+	w.mu.Unlock()
+
+	if w.statusFile != "" {
+		content := fmt.Sprintf("state=%s\nlast_request=%s\nchanged=%s\n",
+			state, last.Format(time.RFC3339), time.Now().Format(time.RFC3339))
+		if err := os.WriteFile(w.statusFile, []byte(content), 0644); err != nil {
+			log.Printf("Failed to write status file: %v", err)
+		}
+	}
+
+	if w.hook != "" {
+		cmd := exec.Command("sh", "-c", w.hook)
+		cmd.Env = append(os.Environ(),
+			"ECHO_MONITOR_STATE="+state,
+			"ECHO_MONITOR_ADDR="+monitor,
+			"ECHO_MONITOR_LAST_REQUEST="+last.Format(time.RFC3339),
+		)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		// Run in the background so a slow hook does not delay echo responses
+		go func() {
+			if err := cmd.Run(); err != nil {
+				log.Printf("Monitor hook failed: %v", err)
+			}
+		}()
+	}
+}
+
 func main() {
 	port := flag.String("port", "8054", "UDP port to listen on")
 	respond := flag.Bool("respond", true, "Whether to respond to echo requests")
+	monitorTimeout := flag.Duration("monitor-timeout", 0, "Report the monitor as lost after this long without an echo request (0 disables)")
+	monitorHook := flag.String("monitor-hook", "", "Shell command to run when the monitor is lost or restored (state in $ECHO_MONITOR_STATE)")
+	statusFile := flag.String("status-file", "", "File to write the monitor state to on every change")
+	maxPayload := flag.Int("max-payload", 0, "Ignore probes larger than this many bytes, to simulate a constrained path (0 disables)")
+	capturePath := flag.String("capture", "", "Write every sent and received datagram to this pcap file")
 	flag.Parse()
 
 	addr, err := net.ResolveUDPAddr("udp", ":"+*port)
@@ -28,31 +137,101 @@ func main() {
 
 	log.Printf("Client listening on port %s, will respond: %v", *port, *respond)
 
+	var capture *pcap.Writer
+	if *capturePath != "" {
+		capture, err = pcap.Create(*capturePath)
+		if err != nil {
+			log.Fatalf("Failed to create capture file: %v", err)
+		}
+		log.Printf("Capturing datagrams to %s", *capturePath)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	record := func(at time.Time, src, dst *net.UDPAddr, payload []byte) {
+		if capture == nil {
+			return
+		}
+		if err := capture.WritePacket(at, src, dst, payload); err != nil {
+			log.Printf("Failed to capture datagram: %v", err)
+		}
+	}
+	send := func(payload []byte, to *net.UDPAddr) error {
+		_, err := conn.WriteToUDP(payload, to)
+		if err == nil {
+			record(time.Now(), local, to, payload)
+		}
+		return err
+	}
+
+	var watch *monitorWatch
+	stop := make(chan struct{})
+	if *monitorTimeout > 0 {
+		watch = newMonitorWatch(*monitorTimeout, *monitorHook, *statusFile)
+		go watch.run(stop)
+		log.Printf("Watching for the monitor server, timeout %s", *monitorTimeout)
+	}
+
 	// Handle graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigCh
 		log.Println("Shutting down...")
+		close(stop)
+		if capture != nil {
+			capture.Close()
+		}
 		conn.Close()
 		os.Exit(0)
 	}()
 
-	buffer := make([]byte, 1024)
+	// Large enough for any UDP payload, so path MTU probes are never truncated
+	buffer := make([]byte, 65507)
 	for {
 		n, addr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
 			log.Printf("Error reading from UDP: %v", err)
 			continue
 		}
+		received := time.Now()
+		record(received, addr, local, buffer[:n])
+
+		// Path MTU probes are padded to their full size, echo them back the same size
+		if bytes.HasPrefix(buffer[:n], []byte("ECHO-PROBE ")) {
+			if watch != nil {
+				watch.seen(addr)
+			}
+			if !*respond || (*maxPayload > 0 && n > *maxPayload) {
+				log.Printf("Not responding to %d-byte probe", n)
+				continue
+			}
+			response := make([]byte, n)
+			h := copy(response, fmt.Sprintf("ECHO-PROBE-RESPONSE %d ", n))
+			for i := h; i < n; i++ {
+				response[i] = 'x'
+			}
+			if err := send(response, addr); err != nil {
+				log.Printf("Failed to send probe response: %v", err)
+			}
+			continue
+		}
 
 		message := string(buffer[:n])
 		log.Printf("Received message: %s from %s", message, addr)
 
-		if message == "ECHO-REQUEST" && *respond {
+		fields := strings.Fields(message)
+		isRequest := len(fields) > 0 && fields[0] == "ECHO-REQUEST"
+
+		if isRequest && watch != nil {
+			watch.seen(addr)
+		}
+
+		if isRequest && *respond {
 			response := []byte("ECHO-RESPONSE")
-			_, err := conn.WriteToUDP(response, addr)
-			if err != nil {
+			// Echo the server's t1 back with our receive (t2) and send (t3) times
+			if len(fields) == 2 {
+				response = []byte(fmt.Sprintf("ECHO-RESPONSE %s %d %d", fields[1], received.UnixNano(), time.Now().UnixNano()))
+			}
+			if err := send(response, addr); err != nil {
 				log.Printf("Failed to send response: %v", err)
 			} else {
 				log.Printf("Sent response to %s", addr)
@@ -0,0 +1,337 @@
+// Package pcap writes and reads UDP datagrams in the classic libpcap file format,
+// so echo traffic captured by the server or client can be opened in Wireshark
+// or replayed later.
+//
+// Only the UDP payload and the two endpoints are known to the application, so
+// each packet is stored with a synthesized IPv4 or IPv6 header and a UDP header
+// under LINKTYPE_RAW.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	magicMicroseconds = 0xa1b2c3d4
+	versionMajor      = 2
+	versionMinor      = 4
+	snapLen           = 262144
+	linkTypeRaw       = 101 // Raw IPv4/IPv6, no link layer header
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+	protocolUDP   = 17
+)
+
+// Packet is one captured UDP datagram.
+type Packet struct {
+	Time    time.Time
+	Src     *net.UDPAddr
+	Dst     *net.UDPAddr
+	Payload []byte
+}
+
+// Writer appends packets to a pcap stream. It is safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewWriter writes the pcap file header to w and returns a Writer for it.
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], magicMicroseconds)
+	binary.LittleEndian.PutUint16(header[4:], versionMajor)
+	binary.LittleEndian.PutUint16(header[6:], versionMinor)
+	// thiszone and sigfigs stay zero
+	binary.LittleEndian.PutUint32(header[16:], snapLen)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeRaw)
+	if _, err := bw.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw}, nil
+}
+
+// Create creates (or truncates) the named file and returns a Writer for it.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+// WritePacket records a datagram sent from src to dst at time ts. An
+// unspecified or missing local address is written as the zero address of the
+// other endpoint's family.
+func (w *Writer) WritePacket(ts time.Time, src, dst *net.UDPAddr, payload []byte) error {
+	frame := encodeFrame(src, dst, payload)
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(record); err != nil {
+		return err
+	}
+	_, err := w.w.Write(frame)
+	return err
+}
+
+// Flush writes any buffered packets to the underlying writer.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Flush()
+}
+
+// Close flushes the writer and closes the file if it was opened by Create.
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// encodeFrame builds the IP and UDP headers around payload.
+func encodeFrame(src, dst *net.UDPAddr, payload []byte) []byte {
+	srcIP, dstIP := addrIP(src), addrIP(dst)
+	v4 := srcIP.To4() != nil && dstIP.To4() != nil
+	if srcIP.IsUnspecified() || srcIP == nil {
+		srcIP = unspecifiedLike(dstIP)
+		v4 = dstIP.To4() != nil
+	} else if dstIP.IsUnspecified() || dstIP == nil {
+		dstIP = unspecifiedLike(srcIP)
+		v4 = srcIP.To4() != nil
+	}
+
+	udpLen := udpHeaderLen + len(payload)
+	udp := make([]byte, udpLen)
+	binary.BigEndian.PutUint16(udp[0:], uint16(addrPort(src)))
+	binary.BigEndian.PutUint16(udp[2:], uint16(addrPort(dst)))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	copy(udp[udpHeaderLen:], payload)
+
+	if v4 {
+		s, d := srcIP.To4(), dstIP.To4()
+		ip := make([]byte, ipv4HeaderLen)
+		ip[0] = 0x45 // Version 4, 5 words
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderLen+udpLen))
+		ip[8] = 64 // TTL
+		ip[9] = protocolUDP
+		copy(ip[12:], s)
+		copy(ip[16:], d)
+		binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+
+		pseudo := make([]byte, 12)
+		copy(pseudo[0:], s)
+		copy(pseudo[4:], d)
+		pseudo[9] = protocolUDP
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(udpLen))
+		binary.BigEndian.PutUint16(udp[6:], udpChecksum(pseudo, udp))
+		return append(ip, udp...)
+	}
+
+	s, d := srcIP.To16(), dstIP.To16()
+	ip := make([]byte, ipv6HeaderLen)
+	ip[0] = 0x60 // Version 6
+	binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+	ip[6] = protocolUDP
+	ip[7] = 64 // Hop limit
+	copy(ip[8:], s)
+	copy(ip[24:], d)
+
+	pseudo := make([]byte, 40)
+	copy(pseudo[0:], s)
+	copy(pseudo[16:], d)
+	binary.BigEndian.PutUint32(pseudo[32:], uint32(udpLen))
+	pseudo[39] = protocolUDP
+	binary.BigEndian.PutUint16(udp[6:], udpChecksum(pseudo, udp))
+	return append(ip, udp...)
+}
+
+func addrIP(a *net.UDPAddr) net.IP {
+	if a == nil {
+		return nil
+	}
+	return a.IP
+}
+
+func addrPort(a *net.UDPAddr) int {
+	if a == nil {
+		return 0
+	}
+	return a.Port
+}
+
+func unspecifiedLike(ip net.IP) net.IP {
+	if ip.To4() != nil {
+		return net.IPv4zero
+	}
+	return net.IPv6unspecified
+}
+
+// checksum folds the one's complement sum of b into sum.
+func checksum(sum uint32, b []byte) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func udpChecksum(pseudo, udp []byte) uint16 {
+	sum := ^checksum(0, pseudo)
+	c := checksum(uint32(sum), udp)
+	if c == 0 {
+		return 0xffff // Zero means "no checksum" in UDP
+	}
+	return c
+}
+
+// Reader reads packets from a pcap stream written by Writer, or any other
+// LINKTYPE_RAW capture of UDP traffic.
+type Reader struct {
+	r       *bufio.Reader
+	order   binary.ByteOrder
+	nanos   bool
+	maxSize uint32 // Largest record accepted, from the header's snaplen
+}
+
+// ErrNotUDP is returned by ReadPacket for a record that is not an IPv4 or IPv6
+// UDP datagram. The record is skipped, so reading may continue.
+var ErrNotUDP = errors.New("pcap: record is not a UDP datagram")
+
+// NewReader reads and validates the pcap file header.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("pcap: reading file header: %w", err)
+	}
+
+	reader := &Reader{r: br}
+	switch {
+	case binary.LittleEndian.Uint32(header) == magicMicroseconds:
+		reader.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == magicMicroseconds:
+		reader.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == 0xa1b23c4d:
+		reader.order, reader.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == 0xa1b23c4d:
+		reader.order, reader.nanos = binary.BigEndian, true
+	default:
+		return nil, errors.New("pcap: not a pcap file")
+	}
+
+	if linkType := reader.order.Uint32(header[20:]); linkType != linkTypeRaw {
+		return nil, fmt.Errorf("pcap: unsupported link type %d", linkType)
+	}
+
+	// Never trust the header for more than the largest snaplen tools write
+	reader.maxSize = reader.order.Uint32(header[16:])
+	if reader.maxSize == 0 || reader.maxSize > snapLen {
+		reader.maxSize = snapLen
+	}
+	return reader, nil
+}
+
+// ReadPacket returns the next packet, or io.EOF at the end of the stream.
+func (r *Reader) ReadPacket() (Packet, error) {
+	record := make([]byte, 16)
+	if _, err := io.ReadFull(r.r, record); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Packet{}, fmt.Errorf("pcap: truncated record header")
+		}
+		return Packet{}, err
+	}
+
+	sec := int64(r.order.Uint32(record[0:]))
+	frac := int64(r.order.Uint32(record[4:]))
+	if !r.nanos {
+		frac *= 1000
+	}
+	size := r.order.Uint32(record[8:])
+	if size > r.maxSize {
+		return Packet{}, fmt.Errorf("pcap: corrupt record of %d bytes, larger than the %d-byte snaplen", size, r.maxSize)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		return Packet{}, fmt.Errorf("pcap: truncated record: %w", err)
+	}
+
+	packet, ok := decodeFrame(frame)
+	if !ok {
+		return Packet{}, ErrNotUDP
+	}
+	packet.Time = time.Unix(sec, frac)
+	return packet, nil
+}
+
+// decodeFrame parses the IP and UDP headers of a raw frame.
+func decodeFrame(frame []byte) (Packet, bool) {
+	if len(frame) == 0 {
+		return Packet{}, false
+	}
+
+	var srcIP, dstIP net.IP
+	var udp []byte
+	switch frame[0] >> 4 {
+	case 4:
+		ihl := int(frame[0]&0x0f) * 4
+		if len(frame) < ihl+udpHeaderLen || ihl < ipv4HeaderLen || frame[9] != protocolUDP {
+			return Packet{}, false
+		}
+		srcIP = net.IP(append([]byte(nil), frame[12:16]...))
+		dstIP = net.IP(append([]byte(nil), frame[16:20]...))
+		udp = frame[ihl:]
+	case 6:
+		if len(frame) < ipv6HeaderLen+udpHeaderLen || frame[6] != protocolUDP {
+			return Packet{}, false
+		}
+		srcIP = net.IP(append([]byte(nil), frame[8:24]...))
+		dstIP = net.IP(append([]byte(nil), frame[24:40]...))
+		udp = frame[ipv6HeaderLen:]
+	default:
+		return Packet{}, false
+	}
+
+	udpLen := int(binary.BigEndian.Uint16(udp[4:]))
+	if udpLen < udpHeaderLen || udpLen > len(udp) {
+		return Packet{}, false
+	}
+	return Packet{
+		Src:     &net.UDPAddr{IP: srcIP, Port: int(binary.BigEndian.Uint16(udp[0:]))},
+		Dst:     &net.UDPAddr{IP: dstIP, Port: int(binary.BigEndian.Uint16(udp[2:]))},
+		Payload: udp[udpHeaderLen:udpLen],
+	}, true
+}
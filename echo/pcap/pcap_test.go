@@ -0,0 +1,38 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPacketRejectsOversizedRecord(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8053}
+	dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8054}
+	if err := w.WritePacket(time.Now(), src, dst, []byte("ECHO-REQUEST")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt incl_len of the first record, just after the 24-byte file header
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[24+8:], 0xfffffff0)
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadPacket(); err == nil || !strings.Contains(err.Error(), "snaplen") {
+		t.Fatalf("ReadPacket() error = %v, want a corrupt record error", err)
+	}
+}
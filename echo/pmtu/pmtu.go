@@ -0,0 +1,23 @@
+// Package pmtu sets a UDP socket to never fragment what it sends, so that
+// datagrams larger than the path MTU are dropped, or refused with EMSGSIZE
+// once the kernel has learned the MTU, instead of arriving in pieces.
+package pmtu
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrUnsupported is returned on platforms where the don't-fragment bit
+// cannot be set.
+var ErrUnsupported = errors.New("pmtu: don't-fragment is not supported on this platform")
+
+// DontFragment sets the don't-fragment bit on every datagram sent from conn,
+// over IPv4 and, for IPv6 sockets, over IPv6.
+func DontFragment(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return dontFragment(raw)
+}
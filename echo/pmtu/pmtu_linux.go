@@ -0,0 +1,30 @@
+//go:build linux
+
+package pmtu
+
+import "syscall"
+
+// ipv6DontFrag is IPV6_DONTFRAG from linux/in6.h, which the syscall package
+// does not export.
+const ipv6DontFrag = 62
+
+// dontFragment sets IP_MTU_DISCOVER to IP_PMTUDISC_DO and IPV6_DONTFRAG. An
+// IPv4 socket rejects the IPv6 option, so it only has to succeed for one of them.
+func dontFragment(c syscall.RawConn) error {
+	var v4Err, v6Err error
+	err := c.Control(func(fd uintptr) {
+		v4Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		v6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6DontFrag, 1)
+		if v6Err == nil {
+			// Also stop the kernel fragmenting to a cached path MTU
+			v6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if v4Err != nil && v6Err != nil {
+		return v4Err
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package pmtu
+
+import "syscall"
+
+func dontFragment(c syscall.RawConn) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,50 @@
+// Package reuseport opens several UDP sockets bound to the same address, so
+// the kernel can spread incoming datagrams across independent readers.
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// ErrUnsupported is returned when more than one socket is requested on a
+// platform without SO_REUSEPORT load balancing.
+var ErrUnsupported = errors.New("reuseport: SO_REUSEPORT is not supported on this platform")
+
+// ListenUDP opens n UDP sockets on address. With n == 1 it is a plain
+// net.ListenUDP; otherwise every socket is bound with SO_REUSEPORT.
+// When address uses port 0, all sockets share the port picked for the first.
+func ListenUDP(network, address string, n int) ([]*net.UDPConn, error) {
+	if n <= 1 {
+		addr, err := net.ResolveUDPAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.ListenUDP(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+	if !supported {
+		return nil, ErrUnsupported
+	}
+
+	lc := net.ListenConfig{Control: control}
+	conns := make([]*net.UDPConn, 0, n)
+	for i := 0; i < n; i++ {
+		pc, err := lc.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conn := pc.(*net.UDPConn)
+		conns = append(conns, conn)
+		// Pin the port chosen by the kernel for the remaining sockets
+		address = conn.LocalAddr().String()
+	}
+	return conns, nil
+}
@@ -0,0 +1,25 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !sparc64
+
+package reuseport
+
+import (
+	"syscall"
+)
+
+const supported = true
+
+// soReusePort is SO_REUSEPORT from asm-generic/socket.h, which the syscall
+// package does not export. MIPS and SPARC use a different value.
+const soReusePort = 0xf
+
+// control sets SO_REUSEPORT on the socket before it is bound.
+func control(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
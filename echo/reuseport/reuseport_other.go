@@ -0,0 +1,11 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le || sparc64
+
+package reuseport
+
+import "syscall"
+
+const supported = false
+
+func control(network, address string, c syscall.RawConn) error {
+	return ErrUnsupported
+}
@@ -1,26 +1,189 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/quyenhl16/go-dspt/echo/pcap"
+	"github.com/quyenhl16/go-dspt/echo/pmtu"
+	"github.com/quyenhl16/go-dspt/echo/reuseport"
 )
 
 type Client struct {
 	Address  *net.UDPAddr
+	Host     string // Registered host:port when the client was added by name
 	LastSeen time.Time
 	Active   bool
+	Tags     []string // Groups this client belongs to
 	mu       sync.Mutex
+
+	// Clock estimates from NTP-style timestamps in the echo exchange
+	Offset  time.Duration // Client clock minus server clock
+	Delay   time.Duration // Round-trip network delay of the sample used for Offset
+	Drift   float64       // Offset change rate in parts per million
+	samples []clockSample
+	skewed  bool
+	// timestamps sends this client timestamped echo requests; see Server.Timestamps.
+	timestamps bool
+
+	// PathMTU is the largest echo payload, in bytes, the client returned intact
+	// during the last probe (0 until probed, or if even the smallest probe was lost). Probes are sent with the
+	// don't-fragment bit set, so this is the path MTU less the IP and UDP headers.
+	PathMTU    int
+	mtuDropped bool
+	probeLost  bool // The last probe failed even at minProbeSize
+
+	// responses is signalled by listenForResponses when an ECHO-RESPONSE arrives,
+	// so that pingClient can stop retrying.
+	responses chan struct{}
+	// probes carries the payload sizes of returned ECHO-PROBE datagrams.
+	probes chan int
+}
+
+func newClient(addr *net.UDPAddr, tags []string) *Client {
+	return &Client{
+		Address:   addr,
+		Tags:      tags,
+		responses: make(chan struct{}, 1),
+		probes:    make(chan int, 1),
+	}
 }
 
-func (c *Client) setActive(active bool) {
+// setActive updates the client state and reports whether it changed.
+// at is when the client was last heard from, used only when active.
+func (c *Client) setActive(active bool, at time.Time) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	changed := c.Active != active
 	c.Active = active
 	if active {
-		c.LastSeen = time.Now()
+		c.LastSeen = at
+	}
+	return changed
+}
+
+func (c *Client) setTimestamps(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timestamps = on
+}
+
+func (c *Client) wantsTimestamps() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timestamps
+}
+
+func (c *Client) hasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// clockSample is one t1..t4 exchange:
+// t1 server send, t2 client receive, t3 client send, t4 server receive.
+type clockSample struct {
+	at     time.Time // Server time when the sample was taken (t4)
+	offset time.Duration
+	delay  time.Duration
+}
+
+const maxClockSamples = 8
+
+func newClockSample(t1, t2, t3, t4 time.Time) clockSample {
+	return clockSample{
+		at:     t4,
+		offset: (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		delay:  t4.Sub(t1) - t3.Sub(t2),
+	}
+}
+
+// addClockSample records a sample and refreshes Offset, Delay and Drift.
+// Like NTP's clock filter, the offset is taken from the recent sample with the
+// lowest delay, as it is the least distorted by queuing.
+func (c *Client) addClockSample(sample clockSample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > maxClockSamples {
+		c.samples = c.samples[1:]
+	}
+
+	best := c.samples[0]
+	for _, s := range c.samples[1:] {
+		if s.delay < best.delay {
+			best = s
+		}
+	}
+	c.Offset = best.offset
+	c.Delay = best.delay
+	c.Drift = clockDrift(c.samples)
+}
+
+// clockDrift fits a least-squares line through the offsets and returns its
+// slope in parts per million.
+func clockDrift(samples []clockSample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	origin := samples[0].at
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := s.at.Sub(origin).Seconds()
+		y := s.offset.Seconds()
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	n := float64(len(samples))
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denom * 1e6
+}
+
+// clock returns the current clock estimates.
+func (c *Client) clock() (offset, delay time.Duration, drift float64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Offset, c.Delay, c.Drift, len(c.samples) > 0
+}
+
+// setSkewed updates the skew alert state and reports whether it changed.
+func (c *Client) setSkewed(skewed bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := c.skewed != skewed
+	c.skewed = skewed
+	return changed
+}
+
+// notifyResponse wakes up a pending pingClient without blocking the reader.
+func (c *Client) notifyResponse() {
+	select {
+	case c.responses <- struct{}{}:
+	default:
 	}
 }
 
@@ -30,86 +193,584 @@ func (c *Client) isActive() bool {
 	return c.Active
 }
 
+// GroupRule decides whether a group has enough active members.
+// A zero field is ignored; when both are set, both must hold.
+type GroupRule struct {
+	MinActive  int     // e.g. 2 for "at least 2 of 3 active"
+	MinPercent float64 // e.g. 60 for "at least 60% active"
+}
+
+func (r GroupRule) String() string {
+	var parts []string
+	if r.MinActive > 0 {
+		parts = append(parts, fmt.Sprintf(">=%d active", r.MinActive))
+	}
+	if r.MinPercent > 0 {
+		parts = append(parts, fmt.Sprintf(">=%.0f%% active", r.MinPercent))
+	}
+	if len(parts) == 0 {
+		return "any"
+	}
+	return strings.Join(parts, " and ")
+}
+
+// satisfied reports whether active out of total members meets the rule.
+// An empty group is never healthy.
+func (r GroupRule) satisfied(active, total int) bool {
+	if total == 0 {
+		return false
+	}
+	if r.MinActive > 0 && active < r.MinActive {
+		return false
+	}
+	if r.MinPercent > 0 && float64(active)*100 < r.MinPercent*float64(total) {
+		return false
+	}
+	return true
+}
+
+// GroupStatus is a snapshot of a group's health.
+type GroupStatus struct {
+	Name    string
+	Rule    GroupRule
+	Active  int
+	Total   int
+	Healthy bool
+}
+
+func (g GroupStatus) String() string {
+	status := "unhealthy"
+	if g.Healthy {
+		status = "healthy"
+	}
+	return fmt.Sprintf("%s: %s (%d/%d active, rule: %s)", g.Name, status, g.Active, g.Total, g.Rule)
+}
+
+// group is a named quorum rule over all clients carrying the same tag.
+type group struct {
+	rule    GroupRule
+	healthy bool
+}
+
+// Resolver looks up the addresses of a host name. *net.Resolver satisfies it,
+// tests can substitute a stand-in that answers locally.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// hostEntry is a client registered by name. Every address the name resolves
+// to is monitored as a separate endpoint of the same logical client.
+type hostEntry struct {
+	host      string
+	port      int
+	tags      []string
+	mu        sync.Mutex
+	endpoints map[string]bool // Keys into Server.clients
+	// timestamps is given to every endpoint, including ones resolved later.
+	timestamps bool
+}
+
+const resolveTimeout = 5 * time.Second
+
 type Server struct {
-	conn        *net.UDPConn
+	conn        *net.UDPConn   // Socket used for sending, conns[0]
+	conns       []*net.UDPConn // One reader goroutine per socket
 	clients     map[string]*Client
 	clientsLock sync.RWMutex
+
+	// snapshot is a read-only copy of clients, replaced on every change, so
+	// the datagram readers can look clients up without touching clientsLock.
+	snapshot atomic.Pointer[map[string]*Client]
+
+	hosts     map[string]*hostEntry
+	hostsLock sync.Mutex
+
+	// Resolver resolves clients registered by host name (net.DefaultResolver by default).
+	Resolver Resolver
+	// ResolveTTL is how often host names are resolved again (0 disables re-resolution).
+	ResolveTTL time.Duration
+
+	groups     map[string]*group
+	groupsLock sync.Mutex
+
+	// OnGroupTransition is called whenever a group flips between healthy and unhealthy.
+	OnGroupTransition func(GroupStatus)
+
+	// Timestamps sends every client "ECHO-REQUEST <t1>" instead of the plain
+	// request, for clock offset estimation. Clients that predate it only
+	// answer the plain request, so until they are all upgraded leave this
+	// off and opt clients in one by one with EnableTimestamps.
+	Timestamps bool
+
+	// MaxClockSkew is the largest tolerated client clock offset (0 disables the check).
+	MaxClockSkew time.Duration
+	// OnClockSkew is called when a client's offset crosses MaxClockSkew in either direction.
+	OnClockSkew func(address string, offset time.Duration, skewed bool)
+
+	// ProbeInterval enables path MTU probing of active clients (0 disables it).
+	ProbeInterval time.Duration
+	// OnPathMTUDrop is called when a probe finds a smaller path MTU than the previous one.
+	OnPathMTUDrop func(address string, previous, current int)
+
+	// Capture, when set, records every datagram sent and received.
+	Capture *pcap.Writer
+
+	dontFragment sync.Once
+}
+
+const (
+	// maxDatagramSize is the largest UDP payload over IPv4.
+	maxDatagramSize = 65507
+	// minProbeSize is the smallest probe; a client that cannot return it is not probed further.
+	minProbeSize = 64
+	probeTimeout = 1 * time.Second
+	probeRetries = 2
+)
+
+// bufferPool holds datagram buffers shared by the reader goroutines.
+var bufferPool = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, maxDatagramSize)
+		return &buffer
+	},
 }
 
 func NewServer(address string) (*Server, error) {
-	addr, err := net.ResolveUDPAddr("udp", address)
+	return NewServerWithSockets(address, 1)
+}
+
+// NewServerWithSockets opens sockets UDP sockets on the same address with
+// SO_REUSEPORT (Linux only), each read by its own goroutine, so the kernel
+// spreads responses across them.
+func NewServerWithSockets(address string, sockets int) (*Server, error) {
+	conns, err := reuseport.ListenUDP("udp", address, sockets)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
+	s := &Server{
+		conn:     conns[0],
+		conns:    conns,
+		clients:  make(map[string]*Client),
+		hosts:    make(map[string]*hostEntry),
+		groups:   make(map[string]*group),
+		Resolver: net.DefaultResolver,
+	}
+	s.publishClients()
+	return s, nil
+}
+
+// publishClients replaces the readers' snapshot of clients.
+// Callers must hold clientsLock for writing, or own the server exclusively.
+func (s *Server) publishClients() {
+	snapshot := make(map[string]*Client, len(s.clients))
+	for key, client := range s.clients {
+		snapshot[key] = client
+	}
+	s.snapshot.Store(&snapshot)
+}
+
+// lookupClient finds a client by address without locking.
+func (s *Server) lookupClient(key string) (*Client, bool) {
+	client, exists := (*s.snapshot.Load())[key]
+	return client, exists
+}
+
+// RegisterClient adds a client to be monitored. Any tags make the client a
+// member of the groups with the same name. A client given by host name is
+// resolved again every ResolveTTL, and each of its addresses is monitored.
+func (s *Server) RegisterClient(address string, tags ...string) error {
+	host, portName, err := net.SplitHostPort(address)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if host == "" || net.ParseIP(host) != nil {
+		udpAddr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			return err
+		}
+
+		s.clientsLock.Lock()
+		s.clients[address] = newClient(udpAddr, tags)
+		s.publishClients()
+		s.clientsLock.Unlock()
+
+		s.evaluateGroups()
+		return nil
+	}
+
+	port, err := net.LookupPort("udp", portName)
+	if err != nil {
+		return err
+	}
+	entry := &hostEntry{
+		host:      host,
+		port:      port,
+		tags:      tags,
+		endpoints: make(map[string]bool),
+	}
+	if err := s.resolveHost(address, entry); err != nil {
+		return err
 	}
 
-	return &Server{
-		conn:    conn,
-		clients: make(map[string]*Client),
-	}, nil
+	s.hostsLock.Lock()
+	s.hosts[address] = entry
+	s.hostsLock.Unlock()
+	return nil
+}
+
+// EnableTimestamps sends timestamped echo requests to a registered client,
+// or to every address of a client registered by host name, whatever
+// Timestamps is set to.
+func (s *Server) EnableTimestamps(address string) error {
+	s.hostsLock.Lock()
+	entry, isHost := s.hosts[address]
+	s.hostsLock.Unlock()
+	if isHost {
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.timestamps = true
+		for key := range entry.endpoints {
+			if client, exists := s.lookupClient(key); exists {
+				client.setTimestamps(true)
+			}
+		}
+		return nil
+	}
+
+	client, exists := s.lookupClient(address)
+	if !exists {
+		return fmt.Errorf("client %s is not registered", address)
+	}
+	client.setTimestamps(true)
+	return nil
 }
 
-func (s *Server) RegisterClient(address string) error {
-	udpAddr, err := net.ResolveUDPAddr("udp", address)
+// resolveHost looks up a registered host name and reconciles its endpoints:
+// new addresses become clients, vanished ones are dropped. On a lookup error
+// the previous endpoints are kept.
+func (s *Server) resolveHost(name string, entry *hostEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	ips, err := s.Resolver.LookupIPAddr(ctx, entry.host)
 	if err != nil {
 		return err
 	}
 
+	wanted := make(map[string]*net.UDPAddr, len(ips))
+	for _, ip := range ips {
+		addr := &net.UDPAddr{IP: ip.IP, Port: entry.port, Zone: ip.Zone}
+		wanted[addr.String()] = addr
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	var added, removed []string
 	s.clientsLock.Lock()
-	s.clients[address] = &Client{
-		Address: udpAddr,
-		Active:  false,
+	for key, addr := range wanted {
+		if !entry.endpoints[key] {
+			client := newClient(addr, entry.tags)
+			client.Host = name
+			client.timestamps = entry.timestamps
+			s.clients[key] = client
+			entry.endpoints[key] = true
+			added = append(added, key)
+		}
+	}
+	for key := range entry.endpoints {
+		if _, ok := wanted[key]; !ok {
+			delete(s.clients, key)
+			delete(entry.endpoints, key)
+			removed = append(removed, key)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		s.publishClients()
 	}
 	s.clientsLock.Unlock()
 
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	for _, key := range added {
+		log.Printf("Host %s now resolves to %s", name, key)
+	}
+	for _, key := range removed {
+		log.Printf("Host %s no longer resolves to %s", name, key)
+	}
+	s.evaluateGroups()
 	return nil
 }
 
+func (s *Server) resolveHostsRoutine() {
+	for {
+		time.Sleep(s.ResolveTTL)
+
+		s.hostsLock.Lock()
+		entries := make(map[string]*hostEntry, len(s.hosts))
+		for name, entry := range s.hosts {
+			entries[name] = entry
+		}
+		s.hostsLock.Unlock()
+
+		for name, entry := range entries {
+			if err := s.resolveHost(name, entry); err != nil {
+				log.Printf("Failed to resolve %s, keeping previous addresses: %v", name, err)
+			}
+		}
+	}
+}
+
+// AddGroup defines the quorum rule for the clients tagged with name.
+func (s *Server) AddGroup(name string, rule GroupRule) {
+	s.groupsLock.Lock()
+	s.groups[name] = &group{rule: rule}
+	s.groupsLock.Unlock()
+
+	s.evaluateGroups()
+}
+
+// GroupStatuses returns the current health of every group, sorted by name.
+func (s *Server) GroupStatuses() []GroupStatus {
+	s.groupsLock.Lock()
+	defer s.groupsLock.Unlock()
+
+	statuses := make([]GroupStatus, 0, len(s.groups))
+	for name, g := range s.groups {
+		statuses = append(statuses, s.groupStatus(name, g))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// groupStatus counts the members of a group. Callers must hold groupsLock.
+func (s *Server) groupStatus(name string, g *group) GroupStatus {
+	status := GroupStatus{Name: name, Rule: g.rule}
+
+	// A client registered by name counts once, active if any endpoint is
+	members := make(map[string]bool)
+	s.clientsLock.RLock()
+	for key, client := range s.clients {
+		if !client.hasTag(name) {
+			continue
+		}
+		if client.Host != "" {
+			key = client.Host
+		}
+		members[key] = members[key] || client.isActive()
+	}
+	s.clientsLock.RUnlock()
+
+	status.Total = len(members)
+	for _, active := range members {
+		if active {
+			status.Active++
+		}
+	}
+
+	status.Healthy = g.rule.satisfied(status.Active, status.Total)
+	return status
+}
+
+// evaluateGroups recomputes every group and fires OnGroupTransition for those
+// whose health changed.
+func (s *Server) evaluateGroups() {
+	var transitions []GroupStatus
+
+	s.groupsLock.Lock()
+	for name, g := range s.groups {
+		status := s.groupStatus(name, g)
+		if status.Healthy != g.healthy {
+			g.healthy = status.Healthy
+			transitions = append(transitions, status)
+		}
+	}
+	s.groupsLock.Unlock()
+
+	// Fire callbacks outside the lock so they may query the server
+	for _, status := range transitions {
+		log.Printf("Group %s", status)
+		if s.OnGroupTransition != nil {
+			s.OnGroupTransition(status)
+		}
+	}
+}
+
+// setClientActive updates a client and re-evaluates groups if its state changed.
+func (s *Server) setClientActive(client *Client, active bool, at time.Time) {
+	if client.setActive(active, at) {
+		s.evaluateGroups()
+	}
+}
+
 func (s *Server) Start() {
-	// Start goroutine to listen for client responses
-	go s.listenForResponses()
+	// Start goroutines to listen for client responses, one per socket
+	for _, conn := range s.conns {
+		go s.listenForResponses(conn)
+	}
 
 	// Start goroutine to periodically ping clients
 	go s.pingClientsRoutine()
+
+	// Start goroutine to periodically probe path MTUs
+	if s.ProbeInterval > 0 {
+		go s.probeClientsRoutine()
+	}
+
+	// Start goroutine to periodically re-resolve clients registered by name
+	if s.ResolveTTL > 0 {
+		go s.resolveHostsRoutine()
+	}
 }
 
-func (s *Server) listenForResponses() {
-	buffer := make([]byte, 1024)
+func (s *Server) listenForResponses(conn *net.UDPConn) {
 	for {
-		n, addr, err := s.conn.ReadFromUDP(buffer)
+		buffer := bufferPool.Get().(*[]byte)
+		n, addr, err := conn.ReadFromUDP(*buffer)
 		if err != nil {
+			bufferPool.Put(buffer)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Printf("Error reading from UDP: %v", err)
 			continue
 		}
 
-		// Process the message
-		message := string(buffer[:n])
-		clientKey := addr.String()
+		received := time.Now()
+		payload := (*buffer)[:n]
+		if s.Capture != nil {
+			if err := s.Capture.WritePacket(received, addr, s.localAddr(), payload); err != nil {
+				log.Printf("Failed to capture datagram: %v", err)
+			}
+		}
 
-		if message == "ECHO-RESPONSE" {
-			s.clientsLock.RLock()
-			client, exists := s.clients[clientKey]
-			s.clientsLock.RUnlock()
+		s.handleDatagram(payload, addr, received)
+		bufferPool.Put(buffer)
+	}
+}
 
-			if exists {
-				client.setActive(true)
-				log.Printf("Client %s marked as active", clientKey)
-			} else {
-				// New client responded, let's add it
-				s.clientsLock.Lock()
-				s.clients[clientKey] = &Client{
-					Address:  addr,
-					LastSeen: time.Now(),
-					Active:   true,
-				}
-				s.clientsLock.Unlock()
-				log.Printf("New client %s registered and marked as active", clientKey)
+// handleDatagram processes one datagram received from addr at the given time.
+// It is shared by the socket reader and capture replay.
+func (s *Server) handleDatagram(payload []byte, addr *net.UDPAddr, received time.Time) {
+	if bytes.HasPrefix(payload, []byte("ECHO-PROBE-RESPONSE ")) {
+		if client, exists := s.lookupClient(addr.String()); exists {
+			select {
+			case client.probes <- len(payload):
+			default:
 			}
 		}
+		return
+	}
+
+	// Process the message
+	fields := strings.Fields(string(payload))
+	clientKey := addr.String()
+
+	if len(fields) > 0 && fields[0] == "ECHO-RESPONSE" {
+		client, exists := s.lookupClient(clientKey)
+
+		if exists {
+			client.notifyResponse()
+			s.setClientActive(client, true, received)
+			log.Printf("Client %s marked as active", clientKey)
+		} else {
+			// New client responded, let's add it, unless another reader just did
+			s.clientsLock.Lock()
+			client, exists = s.clients[clientKey]
+			if !exists {
+				client = newClient(addr, nil)
+				s.clients[clientKey] = client
+				s.publishClients()
+			}
+			s.clientsLock.Unlock()
+			client.setActive(true, received)
+			log.Printf("New client %s registered and marked as active", clientKey)
+		}
+
+		// Only clients sent a timestamped request answer with timestamps
+		if t1, t2, t3, ok := parseTimestamps(fields[1:]); ok {
+			client.addClockSample(newClockSample(t1, t2, t3, received))
+			s.checkClockSkew(clientKey, client)
+		}
+	}
+}
+
+// send writes a datagram to a client, recording it when capturing.
+func (s *Server) send(payload []byte, addr *net.UDPAddr) (int, error) {
+	n, err := s.conn.WriteToUDP(payload, addr)
+	if err == nil && s.Capture != nil {
+		if cerr := s.Capture.WritePacket(time.Now(), s.localAddr(), addr, payload); cerr != nil {
+			log.Printf("Failed to capture datagram: %v", cerr)
+		}
+	}
+	return n, err
+}
+
+func (s *Server) localAddr() *net.UDPAddr {
+	addr, _ := s.conn.LocalAddr().(*net.UDPAddr)
+	return addr
+}
+
+// Replay feeds every datagram of a capture into the server in order, as if it
+// had just been received, so an incident can be reproduced without the network.
+// Datagrams the server sent itself are ignored like any other non-response.
+func (s *Server) Replay(r *pcap.Reader) (int, error) {
+	count := 0
+	for {
+		packet, err := r.ReadPacket()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err == pcap.ErrNotUDP {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		s.handleDatagram(packet.Payload, packet.Src, packet.Time)
+		count++
+	}
+}
+
+// parseTimestamps decodes the t1 t2 t3 fields of an ECHO-RESPONSE,
+// each in Unix nanoseconds.
+func parseTimestamps(fields []string) (t1, t2, t3 time.Time, ok bool) {
+	if len(fields) != 3 {
+		return t1, t2, t3, false
+	}
+	var ts [3]time.Time
+	for i, f := range fields {
+		ns, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return t1, t2, t3, false
+		}
+		ts[i] = time.Unix(0, ns)
+	}
+	return ts[0], ts[1], ts[2], true
+}
+
+// checkClockSkew alerts when a client's clock offset crosses MaxClockSkew.
+func (s *Server) checkClockSkew(clientKey string, client *Client) {
+	if s.MaxClockSkew <= 0 {
+		return
+	}
+	offset, _, drift, _ := client.clock()
+	skewed := offset > s.MaxClockSkew || offset < -s.MaxClockSkew
+	if !client.setSkewed(skewed) {
+		return
+	}
+	if skewed {
+		log.Printf("Client %s clock skewed by %s (drift %.1f ppm), limit %s", clientKey, offset, drift, s.MaxClockSkew)
+	} else {
+		log.Printf("Client %s clock back within %s (offset %s)", clientKey, s.MaxClockSkew, offset)
+	}
+	if s.OnClockSkew != nil {
+		s.OnClockSkew(clientKey, offset, skewed)
 	}
 }
 
@@ -134,16 +795,23 @@ func (s *Server) pingAllClients() {
 }
 
 func (s *Server) pingClient(client *Client) {
-	message := []byte("ECHO-REQUEST")
-	received := make(chan bool, 1)
+	// Discard a late response from a previous round
+	select {
+	case <-client.responses:
+	default:
+	}
 
 	// Try up to 3 times with 3-second timeout
 	for attempt := 1; attempt <= 3; attempt++ {
-		// Send echo request
-		_, err := s.conn.WriteToUDP(message, client.Address)
+		// Stamp the request with t1 for clock offset estimation, if opted in
+		message := []byte("ECHO-REQUEST")
+		if s.Timestamps || client.wantsTimestamps() {
+			message = []byte(fmt.Sprintf("ECHO-REQUEST %d", time.Now().UnixNano()))
+		}
+		_, err := s.send(message, client.Address)
 		if err != nil {
 			log.Printf("Failed to send echo to %s: %v", client.Address, err)
-			client.setActive(false)
+			s.setClientActive(client, false, time.Now())
 			return
 		}
 
@@ -151,13 +819,13 @@ func (s *Server) pingClient(client *Client) {
 
 		// Wait for response or timeout
 		select {
-		case <-received:
+		case <-client.responses:
 			// Response received in listenForResponses(), we're done
 			return
 		case <-time.After(3 * time.Second):
 			if attempt == 3 {
 				// Third attempt failed, mark client as inactive
-				client.setActive(false)
+				s.setClientActive(client, false, time.Now())
 				log.Printf("Client %s marked as inactive after 3 attempts", client.Address)
 			} else {
 				// Retry
@@ -167,37 +835,263 @@ func (s *Server) pingClient(client *Client) {
 	}
 }
 
+func (s *Server) probeClientsRoutine() {
+	for {
+		s.clientsLock.RLock()
+		clientList := make([]*Client, 0, len(s.clients))
+		for _, client := range s.clients {
+			clientList = append(clientList, client)
+		}
+		s.clientsLock.RUnlock()
+
+		// Probe one client at a time, the large datagrams are not free
+		for _, client := range clientList {
+			if client.isActive() {
+				s.ProbePathMTU(client) // Failures are logged and shown in the status
+			}
+		}
+		time.Sleep(s.ProbeInterval)
+	}
+}
+
+// ErrProbeLost is returned by ProbePathMTU when the client did not return even
+// the smallest probe, so its path MTU is unknown.
+var ErrProbeLost = fmt.Errorf("no response to a %d-byte path MTU probe", minProbeSize)
+
+// ProbePathMTU binary searches for the largest echo payload the client returns
+// intact, records it as the client's PathMTU and reports drops. A client
+// whose path was measured before and now returns nothing is reported as a
+// drop to 0.
+func (s *Server) ProbePathMTU(client *Client) (int, error) {
+	// Without DF the kernel fragments large probes, and the search finds the
+	// largest datagram that can be reassembled instead of the path MTU
+	s.dontFragment.Do(func() {
+		if err := pmtu.DontFragment(s.conn); err != nil {
+			log.Printf("Cannot set don't-fragment on probes, path MTU is only an upper bound: %v", err)
+		}
+	})
+
+	if !s.probe(client, minProbeSize) {
+		log.Printf("Client %s did not return a %d-byte probe", client.Address, minProbeSize)
+		s.recordPathMTU(client, 0)
+		return 0, ErrProbeLost
+	}
+
+	// Invariant: lo is known to pass, hi+1 is known to fail (or is past the limit)
+	lo, hi := minProbeSize, maxDatagramSize
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if s.probe(client, mid) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	log.Printf("Client %s path MTU probe: %d bytes", client.Address, lo)
+	s.recordPathMTU(client, lo)
+	return lo, nil
+}
+
+// recordPathMTU stores a probe result, 0 when the smallest probe was lost,
+// and reports a drop from the previous one.
+func (s *Server) recordPathMTU(client *Client, mtu int) {
+	client.mu.Lock()
+	previous := client.PathMTU
+	client.PathMTU = mtu
+	client.probeLost = mtu == 0
+	dropped := previous > 0 && mtu < previous
+	client.mtuDropped = dropped
+	client.mu.Unlock()
+
+	if dropped {
+		log.Printf("Client %s path MTU dropped from %d to %d bytes", client.Address, previous, mtu)
+		if s.OnPathMTUDrop != nil {
+			s.OnPathMTUDrop(client.Address.String(), previous, mtu)
+		}
+	}
+}
+
+// probe sends a padded ECHO-PROBE of exactly size bytes and reports whether
+// the client returned a datagram of the same size.
+func (s *Server) probe(client *Client, size int) bool {
+	message := make([]byte, size)
+	n := copy(message, fmt.Sprintf("ECHO-PROBE %d ", size))
+	for i := n; i < size; i++ {
+		message[i] = 'x'
+	}
+
+	for attempt := 1; attempt <= probeRetries; attempt++ {
+		// Discard responses to earlier probes
+		select {
+		case <-client.probes:
+		default:
+		}
+
+		if _, err := s.send(message, client.Address); err != nil {
+			// EMSGSIZE and friends: larger than the local interface, or
+			// than a path MTU the kernel has learned from ICMP
+			return false
+		}
+
+		timeout := time.After(probeTimeout)
+		for {
+			select {
+			case got := <-client.probes:
+				if got == size {
+					return true
+				}
+				continue // Stale response from an earlier size
+			case <-timeout:
+			}
+			break
+		}
+	}
+	return false
+}
+
 func (s *Server) PrintClientStatus() {
 	s.clientsLock.RLock()
-	defer s.clientsLock.RUnlock()
-
 	fmt.Println("Client Status:")
 	for addr, client := range s.clients {
 		status := "inactive"
 		if client.isActive() {
 			status = "active"
 		}
-		fmt.Printf("%s: %s (Last seen: %s)\n", addr, status, client.LastSeen)
+		tags := ""
+		if client.Host != "" {
+			tags = fmt.Sprintf(" (%s)", client.Host)
+		}
+		if len(client.Tags) > 0 {
+			tags += fmt.Sprintf(" [%s]", strings.Join(client.Tags, ","))
+		}
+		clock := ""
+		if offset, delay, drift, ok := client.clock(); ok {
+			clock = fmt.Sprintf(" offset %s, delay %s, drift %.1f ppm", offset, delay, drift)
+		}
+		client.mu.Lock()
+		switch {
+		case client.probeLost:
+			clock += fmt.Sprintf(" path MTU unknown (%d-byte probe lost)", minProbeSize)
+		case client.PathMTU > 0:
+			clock += fmt.Sprintf(" path MTU %d", client.PathMTU)
+		}
+		if client.mtuDropped {
+			clock += " (dropped)"
+		}
+		client.mu.Unlock()
+		fmt.Printf("%s%s: %s (Last seen: %s)%s\n", addr, tags, status, client.LastSeen, clock)
+	}
+	s.clientsLock.RUnlock()
+
+	groups := s.GroupStatuses()
+	if len(groups) == 0 {
+		return
+	}
+	fmt.Println("Group Status:")
+	for _, g := range groups {
+		fmt.Println(g)
 	}
 }
 
 func main() {
-	server, err := NewServer(":8053")
+	capture := flag.String("capture", "", "Write every sent and received datagram to this pcap file")
+	replay := flag.String("replay", "", "Replay a pcap capture into an in-process server, print the resulting status and exit")
+	sockets := flag.Int("sockets", 1, "Number of SO_REUSEPORT sockets to read responses from (Linux only)")
+	timestamps := flag.Bool("timestamps", false, "Send timestamped echo requests to every client (only once all clients are upgraded)")
+	flag.Parse()
+
+	if *replay != "" {
+		replayCapture(*replay)
+		return
+	}
+
+	server, err := NewServerWithSockets(":8053", *sockets)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
 
+	if *capture != "" {
+		server.Capture, err = pcap.Create(*capture)
+		if err != nil {
+			log.Fatalf("Failed to create capture file: %v", err)
+		}
+		defer server.Capture.Close()
+		log.Printf("Capturing datagrams to %s", *capture)
+	}
+
 	// Register some clients (in a real application, clients might register themselves)
 	// These are example clients you would replace with actual client addresses
-	server.RegisterClient("127.0.0.1:8054")
-	server.RegisterClient("127.0.0.1:8055")
+	server.RegisterClient("127.0.0.1:8054", "web")
+	server.RegisterClient("127.0.0.1:8055", "web")
+	server.RegisterClient("localhost:8056", "web") // Every address of localhost is an endpoint
+
+	// Pick up DNS changes for clients registered by name
+	server.ResolveTTL = 5 * time.Minute
+
+	// The web service is healthy while at least 2 of its 3 hosts respond
+	server.AddGroup("web", GroupRule{MinActive: 2})
+	server.OnGroupTransition = func(g GroupStatus) {
+		if !g.Healthy {
+			log.Printf("ALERT: group %s lost quorum (%d/%d active)", g.Name, g.Active, g.Total)
+		}
+	}
+
+	// Alert when a host's clock drifts more than 500ms from ours. Only the
+	// upgraded hosts are sent timestamped requests, older ones would not answer
+	server.Timestamps = *timestamps
+	server.EnableTimestamps("127.0.0.1:8054")
+	server.MaxClockSkew = 500 * time.Millisecond
+
+	// Probe each host's usable payload size every 10 minutes
+	server.ProbeInterval = 10 * time.Minute
 
 	// Start the server
 	server.Start()
 
-	// Print status every minute
+	// Print status every minute, until interrupted
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	ticker := time.NewTicker(1 * time.Minute)
-	for range ticker.C {
-		server.PrintClientStatus()
+	for {
+		select {
+		case <-ticker.C:
+			server.PrintClientStatus()
+			if server.Capture != nil {
+				server.Capture.Flush()
+			}
+		case <-sigCh:
+			log.Println("Shutting down...")
+			return
+		}
+	}
+}
+
+// replayCapture runs a capture through a fresh server that is never started,
+// so no pings go out and the outcome depends only on the captured datagrams.
+func replayCapture(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open capture: %v", err)
+	}
+	defer f.Close()
+
+	reader, err := pcap.NewReader(f)
+	if err != nil {
+		log.Fatalf("Failed to read capture: %v", err)
+	}
+
+	server, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	server.MaxClockSkew = 500 * time.Millisecond
+
+	n, err := server.Replay(reader)
+	if err != nil {
+		log.Printf("Replay stopped after %d datagrams: %v", n, err)
+	} else {
+		log.Printf("Replayed %d datagrams from %s", n, path)
 	}
+	server.PrintClientStatus()
 }
@@ -0,0 +1,246 @@
+package main
+
+// server.go and client.go are separate programs, so test the server on its own:
+//
+//	go test server.go server_test.go
+//	go test -run NONE -bench Responses -cpu 1,4 server.go server_test.go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubResolver stands in for DNS, answering from a table the test edits.
+type stubResolver struct {
+	mu      sync.Mutex
+	records map[string][]net.IPAddr
+	err     error
+}
+
+func (r *stubResolver) set(host string, ips ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	r.records[host] = addrs
+}
+
+func (r *stubResolver) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+func (r *stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	addrs, ok := r.records[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+// endpoints returns the sorted client keys registered for host.
+func endpoints(s *Server, host string) []string {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	var keys []string
+	for key, client := range s.clients {
+		if client.Host == host {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sameKeys(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResolvedHostEndpoints(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.conn.Close()
+
+	resolver := &stubResolver{records: make(map[string][]net.IPAddr)}
+	resolver.set("db.test", "10.0.0.1", "10.0.0.2", "fd00::1")
+	s.Resolver = resolver
+
+	const name = "db.test:9000"
+	if err := s.RegisterClient(name, "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterClient("127.0.0.1:9001", "db"); err != nil {
+		t.Fatal(err)
+	}
+	s.AddGroup("db", GroupRule{MinActive: 2})
+
+	want := []string{"10.0.0.1:9000", "10.0.0.2:9000", "[fd00::1]:9000"}
+	if got := endpoints(s, name); !sameKeys(got, want) {
+		t.Fatalf("endpoints = %v, want %v", got, want)
+	}
+
+	// Every endpoint of the name answering still makes one active member
+	for _, key := range want {
+		addr, _ := net.ResolveUDPAddr("udp", key)
+		s.handleDatagram([]byte("ECHO-RESPONSE"), addr, time.Now())
+	}
+	status := s.GroupStatuses()[0]
+	if status.Total != 2 || status.Active != 1 || status.Healthy {
+		t.Fatalf("group = %v, want 1/2 active and unhealthy", status)
+	}
+
+	// A record removed from DNS drops its endpoint
+	resolver.set("db.test", "10.0.0.1", "fd00::1")
+	s.hostsLock.Lock()
+	entry := s.hosts[name]
+	s.hostsLock.Unlock()
+	if err := s.resolveHost(name, entry); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"10.0.0.1:9000", "[fd00::1]:9000"}
+	if got := endpoints(s, name); !sameKeys(got, want) {
+		t.Fatalf("endpoints after removal = %v, want %v", got, want)
+	}
+
+	// A failed lookup keeps what was resolved before
+	resolver.fail(errors.New("server misbehaving"))
+	if err := s.resolveHost(name, entry); err == nil {
+		t.Fatal("resolveHost succeeded, want the lookup error")
+	}
+	if got := endpoints(s, name); !sameKeys(got, want) {
+		t.Fatalf("endpoints after lookup error = %v, want %v", got, want)
+	}
+	if status := s.GroupStatuses()[0]; status.Total != 2 || status.Active != 1 {
+		t.Fatalf("group after lookup error = %v, want 1/2 active", status)
+	}
+}
+
+// BenchmarkResponses measures how fast ECHO-RESPONSE datagrams from many
+// clients are handled over loopback, with one reader socket and with four
+// SO_REUSEPORT sockets. Every client keeps one response in flight. The extra
+// sockets only pay off with several CPUs, so compare with -cpu 1,4.
+func BenchmarkResponses(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, sockets := range []int{1, 4} {
+		b.Run(fmt.Sprintf("sockets=%d", sockets), func(b *testing.B) {
+			benchmarkResponses(b, sockets, 64)
+		})
+	}
+}
+
+func benchmarkResponses(b *testing.B, sockets, clients int) {
+	s, err := NewServerWithSockets("127.0.0.1:0", sockets)
+	if err != nil {
+		b.Skip(err) // No SO_REUSEPORT on this platform
+	}
+	for _, conn := range s.conns {
+		defer conn.Close()
+		go s.listenForResponses(conn)
+	}
+	serverAddr := s.conn.LocalAddr().(*net.UDPAddr)
+
+	senders := make([]*net.UDPConn, clients)
+	for i := range senders {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer conn.Close()
+		if err := s.RegisterClient(conn.LocalAddr().String()); err != nil {
+			b.Fatal(err)
+		}
+		senders[i] = conn
+	}
+
+	message := []byte("ECHO-RESPONSE")
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for _, conn := range senders {
+		client, _ := s.lookupClient(conn.LocalAddr().String())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sent.Add(1) <= int64(b.N) {
+				// Resend if the datagram was dropped by a full socket buffer
+				for answered := false; !answered; {
+					if _, err := conn.WriteToUDP(message, serverAddr); err != nil {
+						b.Error(err)
+						return
+					}
+					select {
+					case <-client.responses:
+						answered = true
+					case <-time.After(100 * time.Millisecond):
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestProbeLost(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.conn.Close()
+	go s.listenForResponses(s.conn)
+
+	// A client that never answers, after an earlier probe measured its path
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	key := silent.LocalAddr().String()
+	if err := s.RegisterClient(key); err != nil {
+		t.Fatal(err)
+	}
+	client, _ := s.lookupClient(key)
+	client.PathMTU = 1472
+
+	var drop [2]int
+	s.OnPathMTUDrop = func(_ string, previous, current int) { drop = [2]int{previous, current} }
+
+	if mtu, err := s.ProbePathMTU(client); !errors.Is(err, ErrProbeLost) || mtu != 0 {
+		t.Fatalf("ProbePathMTU() = %d, %v, want 0, ErrProbeLost", mtu, err)
+	}
+	if !client.probeLost || client.PathMTU != 0 {
+		t.Fatalf("client PathMTU %d, probeLost %v, want 0 and lost", client.PathMTU, client.probeLost)
+	}
+	if drop != [2]int{1472, 0} {
+		t.Fatalf("OnPathMTUDrop(%d, %d), want (1472, 0)", drop[0], drop[1])
+	}
+}
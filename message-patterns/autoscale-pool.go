@@ -0,0 +1,56 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// burstyGenerator sends jobs quickly for a while, then slowly, then quickly again
+func burstyGenerator(ctx context.Context, total int) <-chan int {
+	return pipeline.Source(ctx, func(i int) (int, bool) {
+		if i >= total {
+			return 0, false
+		}
+		if i%100 < 50 {
+			time.Sleep(5 * time.Millisecond) // Rush hour
+		} else {
+			time.Sleep(60 * time.Millisecond) // Quiet period
+		}
+		return i, true
+	})
+}
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	jobs := burstyGenerator(ctx, 200)
+
+	pool := pipeline.Autoscale(ctx, jobs, pipeline.AutoscaleConfig{
+		Min:           1,
+		Max:           10,
+		QueueSize:     20,
+		Interval:      100 * time.Millisecond,
+		TargetLatency: 40 * time.Millisecond,
+		UpCooldown:    200 * time.Millisecond,
+		DownCooldown:  time.Second,
+		OnScale: func(e pipeline.ScaleEvent) {
+			fmt.Printf("Scaled %d -> %d workers (%s, backlog %d, latency %s)\n",
+				e.From, e.To, e.Reason, e.Backlog, e.Latency.Round(time.Millisecond))
+		},
+	}, func(ctx context.Context, worker int, job int) int {
+		time.Sleep(50 * time.Millisecond) // simulate work
+		return job * 2
+	})
+
+	processed := 0
+	pipeline.Sink(ctx, pool.Out(), func(int) { processed++ })
+
+	stats := pool.Stats()
+	fmt.Printf("Processed %d jobs, %d workers at the end\n", processed, stats.Workers)
+}
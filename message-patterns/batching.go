@@ -0,0 +1,46 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// Result is a processed job waiting to be written
+type Result struct {
+	JobID  int
+	Output int
+}
+
+// results arrives irregularly: sometimes in bursts, sometimes a trickle
+func results(ctx context.Context, total int) <-chan Result {
+	return pipeline.Source(ctx, func(i int) (Result, bool) {
+		if i >= total {
+			return Result{}, false
+		}
+		time.Sleep(time.Duration(rand.Intn(120)) * time.Millisecond)
+		return Result{JobID: i + 1, Output: rand.Intn(100)}, true
+	})
+}
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Write up to 5 results at once, and never hold one back for more than 200ms
+	batches := pipeline.Batch(ctx, results(ctx, 40), 5, 200*time.Millisecond)
+
+	// range instead of Sink, so a batch flushed on cancellation is still written
+	for batch := range batches {
+		ids := make([]int, len(batch))
+		for i, r := range batch {
+			ids[i] = r.JobID
+		}
+		fmt.Printf("Writing batch of %d results: jobs %v\n", len(batch), ids)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// subscriber reads one broadcast output, taking delay per message
+func subscriber(name string, ch <-chan int, delay time.Duration, wg *sync.WaitGroup) {
+	defer wg.Done()
+	count := 0
+	for range ch {
+		count++
+		time.Sleep(delay)
+	}
+	fmt.Printf("%s received %d messages\n", name, count)
+}
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// One message every 10ms
+	ticks := pipeline.Source(ctx, func(i int) (int, bool) {
+		time.Sleep(10 * time.Millisecond)
+		return i, i < 100
+	})
+
+	// Every subscriber sees every message, unless it falls behind
+	names := []string{"fast (block)", "slow (drop newest)", "slow (drop oldest)", "slow (disconnect)"}
+	b := pipeline.Broadcast(ctx, ticks,
+		pipeline.OutputConfig{Policy: pipeline.Block},
+		pipeline.OutputConfig{Policy: pipeline.DropNewest, Buffer: 5},
+		pipeline.OutputConfig{Policy: pipeline.DropOldest, Buffer: 5},
+		pipeline.OutputConfig{Policy: pipeline.Disconnect, Buffer: 5},
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go subscriber(names[0], b.Out(0), 0, &wg)
+	go subscriber(names[1], b.Out(1), 30*time.Millisecond, &wg)
+	go subscriber(names[2], b.Out(2), 30*time.Millisecond, &wg)
+	go subscriber(names[3], b.Out(3), 30*time.Millisecond, &wg)
+	wg.Wait()
+
+	for i, name := range names {
+		s := b.Stats(i)
+		fmt.Printf("%s: sent %d, dropped %d, max lag %d, disconnected %v\n", name, s.Sent, s.Dropped, s.MaxLag, s.Disconnected)
+	}
+}
@@ -1,11 +1,19 @@
+//go:build ignore
+
 package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"math/rand"
-	"sync"
+	"os"
+	"os/signal"
+	"strconv"
 	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
 )
 
 // Job defines the structure of a job
@@ -21,103 +29,213 @@ type Result struct {
 	Output   int
 }
 
-// cGenerator produces jobs and sends them to a shared channel
+// cGenerator produces a fixed number of jobs, and like a real upstream it now
+// and then delivers the same job twice
 func cGenerator(ctx context.Context, total int) <-chan Job {
-	out := make(chan Job)
-
-	go func() {
-		defer close(out)
-		for i := 1; i <= total; i++ {
-			select {
-			case <-ctx.Done():
-				fmt.Println("Generator stopped")
-				return
-			case out <- Job{ID: i, Value: rand.Intn(100)}:
-				time.Sleep(100 * time.Millisecond)
-			}
+	var last Job
+	return pipeline.Source(ctx, func(i int) (Job, bool) {
+		if i > 0 && rand.Intn(5) == 0 {
+			return last, true // redelivery
 		}
-	}()
-
-	return out
-}
-
-// cWorker processes jobs and sends results to the output channel
-func cWorker(ctx context.Context, id int, jobs <-chan Job, results chan<- Result, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Printf("Worker %d stopped\n", id)
-			return
-		case job, ok := <-jobs:
-			if !ok {
-				return
-			}
-			time.Sleep(300 * time.Millisecond) // simulate work
-			result := Result{
-				WorkerID: id,
-				JobID:    job.ID,
-				Output:   job.Value * 2, // example processing: multiply by 2
-			}
-			select {
-			case <-ctx.Done():
-				return
-			case results <- result:
-			}
+		if last.ID >= total {
+			return Job{}, false
 		}
-	}
+		if i > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		last = Job{ID: last.ID + 1, Value: rand.Intn(100)}
+		return last, true
+	})
 }
 
-// cFanIn collects all results into one channel
-func cFanIn(ctx context.Context, numWorkers int, results <-chan Result) <-chan Result {
-	out := make(chan Result)
-
-	go func() {
-		defer close(out)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case res, ok := <-results:
-				if !ok {
-					return
-				}
-				out <- res
-			}
-		}
-	}()
+var (
+	// errUnprocessable simulates a job the worker cannot handle
+	errUnprocessable = errors.New("value is a multiple of 10")
+	// errBusy simulates a transient failure that is worth retrying
+	errBusy = errors.New("worker busy")
+)
 
-	return out
+// cWorker processes a single job, and fails on some of them
+func cWorker(ctx context.Context, id int, job Job) (Result, error) {
+	result := Result{WorkerID: id, JobID: job.ID}
+	work := time.Duration(200+rand.Intn(200)) * time.Millisecond // simulate work
+	if rand.Intn(10) == 0 {
+		work = 3 * time.Second // simulate a worker that hangs
+	}
+	select {
+	case <-ctx.Done():
+		return result, ctx.Err()
+	case <-time.After(work):
+	}
+	if job.Value == 42 {
+		panic("the answer is not allowed") // recovered by the pipeline
+	}
+	if job.Value%10 == 0 {
+		return result, fmt.Errorf("job %d: %w", job.ID, errUnprocessable)
+	}
+	if rand.Intn(4) == 0 {
+		return result, fmt.Errorf("job %d: %w", job.ID, errBusy)
+	}
+	result.Output = job.Value * 2 // example processing: multiply by 2
+	return result, nil
 }
 
 func main() {
+	ordered := flag.Bool("ordered", false, "Emit results in job order instead of completion order")
+	maxErrors := flag.Int("max-errors", 0, "Abort after this many failed jobs (1 fails fast, 0 collects all)")
+	attempts := flag.Int("attempts", 3, "Attempts per job before it goes to the dead-letter queue")
+	stopAfter := flag.Duration("stop-after", 0, "Stop taking new jobs after this long and drain the rest (0 runs all jobs)")
+	grace := flag.Duration("grace", time.Second, "How long in-flight jobs may take to finish on shutdown")
+	jobTimeout := flag.Duration("job-timeout", time.Second, "Deadline for each job (0 for none)")
+	dedupState := flag.String("dedup-state", "", "File that keeps the job IDs already seen across runs")
+	hedge := flag.Bool("hedge", false, "Start a duplicate of jobs slower than the p95 latency on another worker")
+	metrics := flag.Bool("metrics", false, "Print the stage graph as Graphviz DOT and a bottleneck report at the end")
+	flag.Parse()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Create job source (fan-out input)
-	jobs := cGenerator(ctx, 20)
+	// Failed jobs are reported to the group, which cancels ctx per the policy
+	group, ctx, stop := pipeline.WithFailurePolicy(ctx, pipeline.AbortAfter(*maxErrors))
+	defer stop()
+
+	// Busy workers are retried with backoff, everything else is dead-lettered at once
+	deadLetters := pipeline.NewDeadLetterQueue[Job]()
+	retry := pipeline.RetryPolicy{
+		MaxAttempts: *attempts,
+		BaseDelay:   50 * time.Millisecond,
+		MaxDelay:    time.Second,
+		Jitter:      0.5,
+		Retryable:   func(err error) bool { return errors.Is(err, errBusy) },
+	}
+	retrying := pipeline.Retry(retry, deadLetters, cWorker)
 
-	// Shared result channel for fan-in
-	results := make(chan Result)
+	// Sources run on their own context so shutdown can stop them first
+	life := pipeline.NewLifecycle(ctx)
+	ctx = life.Context()
+
+	// Stages report to m when -metrics is set; a nil *Metrics records nothing
+	var m *pipeline.Metrics
+	if *metrics {
+		m = pipeline.NewMetrics()
+	}
+
+	// Create job source (fan-out input), dropping redelivered jobs by ID
+	source := cGenerator(m.Stage(life.SourceContext(), "generator"), 20)
+	dedup := pipeline.Dedup(m.Stage(ctx, "dedup", "generator"), source, pipeline.DedupConfig[Job]{
+		Key:      func(j Job) string { return strconv.Itoa(j.ID) },
+		TTL:      time.Hour,
+		Capacity: 1000,
+		Path:     *dedupState,
+		OnDuplicate: func(j Job) {
+			fmt.Printf("Duplicate: Job %d dropped\n", j.ID)
+		},
+		OnSaveError: func(err error) {
+			fmt.Println("Dedup state:", err)
+		},
+	})
+	jobs := dedup.Out()
 
 	// Fan-out: Start workers
 	numWorkers := 3
-	var wg sync.WaitGroup
-	for i := 1; i <= numWorkers; i++ {
-		wg.Add(1)
-		go cWorker(ctx, i, jobs, results, &wg)
-	}
 
 	// Fan-in: Collect results from all workers
+	var merged <-chan pipeline.Outcome[Result]
+	var hedged *pipeline.HedgedPool[Job, Result]
+	workersCtx, sinkInput := m.Stage(ctx, "workers", "dedup"), "workers"
+	if *hedge {
+		// Jobs still running at the p95 latency get a second attempt on
+		// another worker; both attempts share the job's deadline
+		hedged = pipeline.Hedged(workersCtx, group, deadLetters, jobs, numWorkers, pipeline.HedgeConfig{
+			Timeout:    *jobTimeout,
+			Hedge:      true,
+			MinSamples: 5,
+		}, retrying)
+		merged = hedged.Out()
+	} else {
+		// Every attempt runs under its own deadline, so a hung worker only
+		// costs that long before the job is retried or dead-lettered
+		attempt := cWorker
+		if *jobTimeout > 0 {
+			attempt = pipeline.Deadline(*jobTimeout, cWorker)
+		}
+		worker := pipeline.Fallible(group, pipeline.Retry(retry, deadLetters, attempt))
+		if *ordered {
+			// Hold at most 5 early results while waiting for a slow job
+			merged = pipeline.OrderedFanOut(workersCtx, jobs, numWorkers, 5, worker)
+		} else {
+			results := pipeline.FanOut(workersCtx, jobs, numWorkers, worker)
+			merged, sinkInput = pipeline.Merge(m.Stage(ctx, "merge", "workers"), results...), "merge"
+		}
+	}
+
+	// Read from the fan-in output
+	life.Go(func() {
+		pipeline.Sink(m.Stage(ctx, "sink", sinkInput), merged, func(o pipeline.Outcome[Result]) {
+			res := o.Value
+			if o.Err != nil && res.JobID == 0 {
+				fmt.Printf("Failed: %v\n", o.Err) // Timed out before the worker returned anything
+				return
+			}
+			if o.Err != nil {
+				fmt.Printf("Failed: Job %d on Worker %d -> %v\n", res.JobID, res.WorkerID, o.Err)
+				return
+			}
+			fmt.Printf("Result: Job %d processed by Worker %d -> Output: %d\n", res.JobID, res.WorkerID, res.Output)
+		})
+	})
+
+	// Shut down gracefully on Ctrl-C or after -stop-after: no new jobs, but
+	// the ones already taken get up to -grace to finish
+	interrupt, stopInterrupt := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stopInterrupt()
+	var stopTimer <-chan time.Time
+	if *stopAfter > 0 {
+		stopTimer = time.After(*stopAfter)
+	}
+	finished := make(chan struct{})
 	go func() {
-		wg.Wait()
-		close(results)
+		life.Wait()
+		close(finished)
 	}()
+	select {
+	case <-finished:
+	case <-interrupt.Done():
+		shutdown(life, *grace)
+	case <-stopTimer:
+		shutdown(life, *grace)
+	}
 
-	// Read from the fan-in output
-	for res := range cFanIn(ctx, numWorkers, results) {
-		fmt.Printf("Result: Job %d processed by Worker %d -> Output: %d\n", res.JobID, res.WorkerID, res.Output)
+	stats := dedup.Stats()
+	fmt.Printf("Dedup: %d jobs passed, %d duplicates dropped\n", stats.Misses, stats.Hits)
+
+	if hedged != nil {
+		stats := hedged.Stats()
+		fmt.Printf("Hedging: %d of %d jobs hedged at %v, %d won by the hedge, %d timed out\n",
+			stats.Hedged, stats.Jobs, stats.Threshold.Round(time.Millisecond), stats.HedgeWins, stats.TimedOut)
+	}
+
+	for _, letter := range deadLetters.Letters() {
+		fmt.Printf("Dead letter: Job %d after %d attempts -> %v\n", letter.Job.ID, len(letter.Attempts), letter.Err())
+	}
+
+	if m != nil {
+		// Render with: dot -Tsvg
+		fmt.Print(m.Topology())
+		fmt.Print(m.Bottleneck())
 	}
 
+	if err := group.Err(); err != nil {
+		fmt.Printf("Pipeline finished with %d errors: %v\n", len(group.Errors()), err)
+		return
+	}
 	fmt.Println("Pipeline complete")
 }
+
+// shutdown drains the pipeline, cancelling it if the grace period runs out
+func shutdown(life *pipeline.Lifecycle, grace time.Duration) {
+	fmt.Println("Shutting down: no new jobs, draining in-flight ones")
+	if err := life.Shutdown(grace); err != nil {
+		fmt.Println("Shutdown:", err)
+	}
+}
@@ -0,0 +1,76 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// Job is one unit of work, stored in the queue as JSON
+type Job struct {
+	ID    int
+	Value int
+}
+
+func main() {
+	dir := flag.String("dir", "queue-data", "directory holding the queue's log")
+	total := flag.Int("jobs", 50, "jobs to enqueue when the queue is empty")
+	crashAfter := flag.Int("crash-after", 0, "exit without acking once this many jobs are done, 0 to run to completion")
+	flag.Parse()
+
+	queue, err := pipeline.OpenQueue[Job](*dir, pipeline.QueueOptions{
+		SegmentSize:       512, // Tiny segments, so compaction is easy to watch
+		VisibilityTimeout: 2 * time.Second,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer queue.Close()
+
+	// Jobs left over from a crashed run are delivered again instead
+	if pending := queue.Len(); pending > 0 {
+		fmt.Printf("Recovered %d unacknowledged jobs from %s\n", pending, *dir)
+	} else {
+		for i := 1; i <= *total; i++ {
+			if _, err := queue.Enqueue(Job{ID: i, Value: i}); err != nil {
+				log.Fatal(err)
+			}
+		}
+		fmt.Printf("Enqueued %d jobs\n", *total)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The queue is a drop-in source for the usual fan-out/fan-in stages
+	outs := pipeline.FanOut(ctx, queue.Source(ctx), 4, func(ctx context.Context, worker int, d pipeline.Delivery[Job]) string {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		result := d.Value.Value * d.Value.Value
+		if err := d.Ack(); err != nil {
+			return fmt.Sprintf("Worker %d: job %d not acked: %v", worker, d.Value.ID, err)
+		}
+		return fmt.Sprintf("Worker %d: job %d (attempt %d) -> %d", worker, d.Value.ID, d.Attempt, result)
+	})
+
+	done := 0
+	for line := range pipeline.Merge(ctx, outs...) {
+		fmt.Println(line)
+		done++
+		if done == *crashAfter {
+			fmt.Printf("Crashing with %d jobs unacknowledged\n", queue.Len())
+			os.Exit(1)
+		}
+		if queue.Len() == 0 {
+			cancel()
+		}
+	}
+	fmt.Println("All jobs acknowledged")
+}
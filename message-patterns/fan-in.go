@@ -1,80 +1,73 @@
+//go:build ignore
+
 package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
 )
 
-// producer generates messages and sends them into its channel until the context is done.
+// producer generates messages until the context is done.
 func producer(ctx context.Context, label string, interval time.Duration) <-chan string {
-	ch := make(chan string)
-
-	go func() {
-		defer close(ch) // Ensure the channel is closed when the goroutine exits
-
-		for i := 0; ; i++ {
-			select {
-			case <-ctx.Done():
-				// Exit the goroutine when the context is cancelled
-				fmt.Println(label, "stopped")
-				return
-			case ch <- fmt.Sprintf("%s: %d", label, i):
-				time.Sleep(interval) // Simulate work by sleeping
-			}
+	return pipeline.Source(ctx, func(i int) (string, bool) {
+		if i > 0 {
+			time.Sleep(interval) // Simulate work by sleeping
 		}
-	}()
-
-	return ch
-}
-
-// cFanIn merges multiple input channels into a single output channel,
-// and stops when the context is cancelled.
-func fanIn(ctx context.Context, channels ...<-chan string) <-chan string {
-	out := make(chan string)
-
-	// Start a goroutine for each input channel
-	for _, ch := range channels {
-		go func(c <-chan string) {
-			for {
-				select {
-				case <-ctx.Done():
-					// Exit goroutine when context is cancelled
-					return
-				case msg, ok := <-c:
-					if !ok {
-						// If the input channel is closed, exit
-						return
-					}
-					out <- msg
-				}
-			}
-		}(ch)
-	}
-
-	return out
+		return fmt.Sprintf("%s: %d", label, i), true
+	})
 }
 
 func main() {
+	mode := flag.String("mode", "race", "How to merge: race, round-robin, priority or weighted")
+	flag.Parse()
+
 	// Create a context with timeout to stop everything after 5 seconds
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel() // Ensure context is cancelled on exit
 
-	// Start two producers
-	ch1 := producer(ctx, "Producer A", 500*time.Millisecond)
-	ch2 := producer(ctx, "Producer B", 700*time.Millisecond)
+	// Start a quiet but important producer and a chatty one
+	alerts := producer(ctx, "Alert", 300*time.Millisecond)
+	metrics := producer(ctx, "Metric", 20*time.Millisecond)
 
-	// Merge the channels using fan-in pattern
-	merged := fanIn(ctx, ch1, ch2)
+	// Merge the channels using fan-in pattern; the merged channel is closed
+	// once both producers stop
+	var merged <-chan string
+	var merger *pipeline.Merger[string]
+	switch *mode {
+	case "race":
+		// One goroutine per input racing for the output: the chatty producer wins most races
+		merged = pipeline.Merge(ctx, alerts, metrics)
+	case "round-robin":
+		merger = pipeline.MergeBy(ctx, pipeline.RoundRobin, pipeline.MergeInput[string]{In: alerts}, pipeline.MergeInput[string]{In: metrics})
+	case "priority":
+		merger = pipeline.MergeBy(ctx, pipeline.StrictPriority, pipeline.MergeInput[string]{In: alerts}, pipeline.MergeInput[string]{In: metrics})
+	case "weighted":
+		merger = pipeline.MergeBy(ctx, pipeline.WeightedFair, pipeline.MergeInput[string]{In: alerts, Weight: 3}, pipeline.MergeInput[string]{In: metrics, Weight: 1})
+	default:
+		fmt.Println("Unknown mode:", *mode)
+		return
+	}
+	if merger != nil {
+		merged = merger.Out()
+	}
+
+	// Read from merged channel until context is done; the consumer is slower
+	// than the producers together, so the merge decides who waits
+	err := pipeline.Sink(ctx, merged, func(msg string) {
+		fmt.Println("Received:", msg)
+		time.Sleep(50 * time.Millisecond)
+	})
+	if err != nil {
+		fmt.Println("Main: context cancelled, shutting down")
+	}
 
-	// Read from merged channel until context is done
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Main: context cancelled, shutting down")
-			return
-		case msg := <-merged:
-			fmt.Println("Received:", msg)
+	if merger != nil {
+		for i, stats := range merger.Stats() {
+			fmt.Printf("Input %d: %d messages, %.1f/s\n", i, stats.Emitted, stats.Rate)
 		}
 	}
 }
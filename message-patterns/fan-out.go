@@ -1,52 +1,31 @@
+//go:build ignore
+
 package main
 
 import (
 	"context"
 	"fmt"
 	"math/rand"
-	"sync"
 	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
 )
 
-// cGenerator sends integers to a channel until context is canceled
+// generator sends random integers until the context is cancelled
 func generator(ctx context.Context) <-chan int {
-	out := make(chan int)
-
-	go func() {
-		defer close(out)
-		for {
-			select {
-			case <-ctx.Done():
-				fmt.Println("Generator stopped")
-				return
-			case out <- rand.Intn(100): // Simulate generating work
-				time.Sleep(300 * time.Millisecond)
-			}
+	return pipeline.Source(ctx, func(i int) (int, bool) {
+		if i > 0 {
+			time.Sleep(300 * time.Millisecond) // Simulate generating work
 		}
-	}()
-
-	return out
+		return rand.Intn(100), true
+	})
 }
 
-// cWorker reads from input channel, processes the job, and prints the result
-func worker(ctx context.Context, id int, jobs <-chan int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Printf("Worker %d stopped\n", id)
-			return
-		case job, ok := <-jobs:
-			if !ok {
-				// Channel closed, exit
-				fmt.Printf("Worker %d: job channel closed\n", id)
-				return
-			}
-			// Simulate work
-			time.Sleep(500 * time.Millisecond)
-			fmt.Printf("Worker %d processed job: %d\n", id, job)
-		}
-	}
+// worker processes one job and reports who handled it
+func worker(ctx context.Context, id int, job int) string {
+	// Simulate work
+	time.Sleep(500 * time.Millisecond)
+	return fmt.Sprintf("Worker %d processed job: %d", id, job)
 }
 
 func main() {
@@ -57,14 +36,12 @@ func main() {
 	jobs := generator(ctx)
 
 	// Fan-out: Start multiple workers consuming from the same job channel
-	var wg sync.WaitGroup
 	numWorkers := 3
-	for i := 1; i <= numWorkers; i++ {
-		wg.Add(1)
-		go worker(ctx, i, jobs, &wg)
-	}
+	done := pipeline.FanOut(ctx, jobs, numWorkers, worker)
 
-	// Wait for all workers to finish
-	wg.Wait()
+	// Print what the workers report until the context times out
+	pipeline.Sink(ctx, pipeline.Merge(ctx, done...), func(msg string) {
+		fmt.Println(msg)
+	})
 	fmt.Println("All workers done")
 }
@@ -0,0 +1,62 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// Update changes the balance of one account; updates to the same account
+// must be applied in the order they were issued
+type Update struct {
+	Account string
+	Seq     int
+	Amount  int
+}
+
+// updates produces a stream of updates spread over a few accounts
+func updates(ctx context.Context, total int) <-chan Update {
+	accounts := []string{"alice", "bob", "carol", "dave", "erin"}
+	seqs := make(map[string]int)
+	return pipeline.Source(ctx, func(i int) (Update, bool) {
+		if i >= total {
+			return Update{}, false
+		}
+		time.Sleep(25 * time.Millisecond)
+		account := accounts[rand.Intn(len(accounts))]
+		seqs[account]++
+		return Update{Account: account, Seq: seqs[account], Amount: rand.Intn(100) - 50}, true
+	})
+}
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Every update for an account goes to the same worker
+	numWorkers := 3
+	pool := pipeline.Partition(ctx, updates(ctx, 40), numWorkers,
+		func(u Update) string { return u.Account },
+		func(ctx context.Context, worker int, u Update) string {
+			time.Sleep(time.Duration(rand.Intn(50)) * time.Millisecond) // simulate work
+			return fmt.Sprintf("Worker %d applied %s #%d (%+d)", worker, u.Account, u.Seq, u.Amount)
+		})
+
+	// Grow the pool halfway through; only the keys owned by the new worker move
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		before := pool.WorkerFor("alice")
+		if pool.Resize(numWorkers + 1) {
+			fmt.Printf("Resized to %d workers, alice now on worker %d (was %d)\n", numWorkers+1, pool.WorkerFor("alice"), before)
+		}
+	}()
+
+	pipeline.Sink(ctx, pool.Out(), func(msg string) {
+		fmt.Println(msg)
+	})
+}
@@ -0,0 +1,283 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AutoscaleConfig bounds and tunes an autoscaling worker pool.
+type AutoscaleConfig struct {
+	Min, Max  int           // Worker count limits; Min is also the initial size
+	QueueSize int           // Jobs buffered ahead of the workers; the backlog is measured here
+	Interval  time.Duration // How often the pool re-evaluates its size
+
+	// ScaleUpBacklog adds workers when this many jobs are waiting,
+	// one per ScaleUpBacklog jobs.
+	ScaleUpBacklog int
+	// TargetLatency adds a worker when the average processing time is above
+	// it and jobs are waiting. Zero ignores latency.
+	TargetLatency time.Duration
+
+	// Cooldowns keep the pool from oscillating: no scale-up within UpCooldown
+	// of the last scaling event, no scale-down within DownCooldown.
+	UpCooldown   time.Duration
+	DownCooldown time.Duration
+
+	// OnScale, if set, is called after every change of the worker count.
+	OnScale func(ScaleEvent)
+}
+
+// ScaleEvent describes one change of the worker count.
+type ScaleEvent struct {
+	At      time.Time
+	From    int
+	To      int
+	Backlog int
+	Latency time.Duration // Average processing time when the decision was made
+	Reason  string
+}
+
+// PoolStats is a snapshot of an autoscaling pool.
+type PoolStats struct {
+	Workers int
+	Busy    int
+	Backlog int
+	Latency time.Duration
+}
+
+// AutoscalePool runs fn on a number of workers that grows and shrinks with
+// the backlog and processing latency.
+type AutoscalePool[In, Out any] struct {
+	cfg   AutoscaleConfig
+	fn    func(ctx context.Context, worker int, v In) Out
+	queue chan In
+	out   chan Out
+
+	busy    atomic.Int64
+	latency atomic.Int64 // Exponentially weighted average, in nanoseconds
+
+	mu        sync.Mutex
+	stops     []chan struct{} // One per running worker, newest last
+	nextID    int
+	lastScale time.Time
+	wg        sync.WaitGroup
+}
+
+// Autoscale starts a pool reading from in. Its output is closed once in is
+// drained, or ctx is cancelled, and every worker has returned.
+func Autoscale[In, Out any](ctx context.Context, in <-chan In, cfg AutoscaleConfig, fn func(ctx context.Context, worker int, v In) Out) *AutoscalePool[In, Out] {
+	if cfg.Min < 1 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = cfg.Max
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 100 * time.Millisecond
+	}
+	if cfg.ScaleUpBacklog < 1 {
+		cfg.ScaleUpBacklog = cfg.QueueSize / 2
+		if cfg.ScaleUpBacklog < 1 {
+			cfg.ScaleUpBacklog = 1
+		}
+	}
+
+	p := &AutoscalePool[In, Out]{
+		cfg:   cfg,
+		fn:    fn,
+		queue: make(chan In, cfg.QueueSize),
+		out:   make(chan Out),
+	}
+
+	// Feed the internal queue, whose length is the backlog
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		defer close(p.queue)
+		for {
+			v, ok := receive(ctx, in)
+			if !ok || !send(unobserved(ctx), p.queue, v) {
+				return
+			}
+		}
+	}()
+
+	p.mu.Lock()
+	for i := 0; i < cfg.Min; i++ {
+		p.startWorker(ctx)
+	}
+	p.lastScale = time.Now()
+	p.mu.Unlock()
+
+	go func() {
+		// Only the scaler starts workers after this point, so once it has
+		// returned the wait group can no longer grow
+		p.scale(ctx, fed)
+		p.wg.Wait()
+		close(p.out)
+	}()
+
+	return p
+}
+
+// Out returns the results of every worker.
+func (p *AutoscalePool[In, Out]) Out() <-chan Out {
+	return p.out
+}
+
+// Stats returns the current size, load and latency of the pool.
+func (p *AutoscalePool[In, Out]) Stats() PoolStats {
+	p.mu.Lock()
+	workers := len(p.stops)
+	p.mu.Unlock()
+	return PoolStats{
+		Workers: workers,
+		Busy:    int(p.busy.Load()),
+		Backlog: len(p.queue),
+		Latency: time.Duration(p.latency.Load()),
+	}
+}
+
+// startWorker adds a worker. Callers must hold p.mu.
+func (p *AutoscalePool[In, Out]) startWorker(ctx context.Context) {
+	stop := make(chan struct{})
+	p.stops = append(p.stops, stop)
+	p.nextID++
+	id := p.nextID
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer p.removeStop(stop)
+		for {
+			// Check stop first so a retired worker does not take another job
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			var v In
+			var ok bool
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case v, ok = <-p.queue:
+				if !ok {
+					return
+				}
+			}
+
+			p.busy.Add(1)
+			start := time.Now()
+			result := p.fn(ctx, id, v)
+			p.observe(time.Since(start))
+			observe(ctx, start)
+			p.busy.Add(-1)
+
+			if !send(ctx, p.out, result) {
+				return
+			}
+		}
+	}()
+}
+
+// removeStop forgets a worker that has returned on its own.
+func (p *AutoscalePool[In, Out]) removeStop(stop chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, s := range p.stops {
+		if s == stop {
+			p.stops = append(p.stops[:i], p.stops[i+1:]...)
+			return
+		}
+	}
+}
+
+// observe folds one processing time into the moving average.
+func (p *AutoscalePool[In, Out]) observe(d time.Duration) {
+	const weight = 0.2
+	for {
+		old := p.latency.Load()
+		next := int64(float64(d))
+		if old != 0 {
+			next = int64(weight*float64(d) + (1-weight)*float64(old))
+		}
+		if p.latency.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// scale periodically resizes the pool until ctx is cancelled, or the input is
+// exhausted (fed is closed) and the queue is drained.
+func (p *AutoscalePool[In, Out]) scale(ctx context.Context, fed <-chan struct{}) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			select {
+			case <-fed:
+				if len(p.queue) == 0 {
+					return
+				}
+			default:
+			}
+
+			p.mu.Lock()
+			event, ok := p.decide(ctx, now)
+			p.mu.Unlock()
+			if ok && p.cfg.OnScale != nil {
+				p.cfg.OnScale(event)
+			}
+		}
+	}
+}
+
+// decide applies one scaling step. Callers must hold p.mu.
+func (p *AutoscalePool[In, Out]) decide(ctx context.Context, now time.Time) (ScaleEvent, bool) {
+	workers := len(p.stops)
+	backlog := len(p.queue)
+	latency := time.Duration(p.latency.Load())
+	idle := workers - int(p.busy.Load())
+	since := now.Sub(p.lastScale)
+
+	event := ScaleEvent{At: now, From: workers, Backlog: backlog, Latency: latency}
+	switch {
+	case workers < p.cfg.Max && since >= p.cfg.UpCooldown && backlog >= p.cfg.ScaleUpBacklog:
+		event.To = min(p.cfg.Max, workers+backlog/p.cfg.ScaleUpBacklog)
+		event.Reason = "backlog"
+	case workers < p.cfg.Max && since >= p.cfg.UpCooldown && p.cfg.TargetLatency > 0 &&
+		latency > p.cfg.TargetLatency && backlog > 0:
+		event.To = workers + 1
+		event.Reason = "latency"
+	case workers > p.cfg.Min && since >= p.cfg.DownCooldown && backlog == 0 && idle > 0:
+		event.To = workers - 1
+		event.Reason = "idle"
+	default:
+		return ScaleEvent{}, false
+	}
+
+	for n := workers; n < event.To; n++ {
+		p.startWorker(ctx)
+	}
+	for n := workers; n > event.To; n-- {
+		// Retire the newest worker; it finishes its current job first
+		last := len(p.stops) - 1
+		close(p.stops[last])
+		p.stops = p.stops[:last]
+	}
+	p.lastScale = now
+	return event, true
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// batchFlushGrace is how long a partial batch is offered to the reader after
+// cancellation before it is dropped.
+const batchFlushGrace = time.Second
+
+// Batch groups values into slices of up to size values. A batch is emitted
+// when it is full, or maxWait after its first value arrived, whichever comes
+// first (maxWait of zero waits for a full batch). The partial batch is
+// flushed when in is closed; on cancellation it is offered to the reader for
+// up to a second more before the output is closed, whatever maxWait is.
+func Batch[T any](ctx context.Context, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	if size < 1 {
+		size = 1
+	}
+	out := make(chan []T)
+
+	go func() {
+		defer close(out)
+
+		var batch []T
+		timer := time.NewTimer(maxWait)
+		timer.Stop()
+		var expired <-chan time.Time // Nil while the batch is empty
+
+		// flush emits the batch; on cancellation it is kept for cancelled
+		flush := func() bool {
+			expired = nil
+			timer.Stop()
+			if len(batch) == 0 {
+				return true
+			}
+			if !send(ctx, out, batch) {
+				return false
+			}
+			batch = nil
+			return true
+		}
+
+		cancelled := func() {
+			if len(batch) == 0 {
+				return
+			}
+			grace, cancel := context.WithTimeout(context.WithoutCancel(ctx), batchFlushGrace)
+			defer cancel()
+			send(grace, out, batch)
+		}
+
+		for {
+			v, ok, fired := receiveUntil(ctx, in, expired)
+			switch {
+			case fired:
+				if !flush() {
+					cancelled()
+					return
+				}
+			case !ok:
+				// Closed input is flushed; cancellation only gets the grace
+				if ctx.Err() != nil || !flush() {
+					cancelled()
+				}
+				return
+			default:
+				batch = append(batch, v)
+				if len(batch) == 1 && maxWait > 0 {
+					timer.Reset(maxWait)
+					expired = timer.C
+				}
+				if len(batch) >= size && !flush() {
+					cancelled()
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Unbatch flattens slices back into a stream of single values.
+func Unbatch[T any](ctx context.Context, in <-chan []T) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+		for {
+			batch, ok := receive(ctx, in)
+			if !ok {
+				return
+			}
+			for _, v := range batch {
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
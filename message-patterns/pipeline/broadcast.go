@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// SlowPolicy decides what a broadcast does when an output falls behind.
+type SlowPolicy int
+
+const (
+	// Block waits for the slow output, holding back every other output too.
+	Block SlowPolicy = iota
+	// DropNewest discards the value that does not fit.
+	DropNewest
+	// DropOldest discards the oldest buffered value to make room.
+	DropOldest
+	// Disconnect closes the output once its buffer overflows.
+	Disconnect
+)
+
+func (p SlowPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// OutputConfig configures one broadcast output.
+type OutputConfig struct {
+	Policy SlowPolicy
+	Buffer int // Values held for the output before the policy applies; without one, any reader that is not waiting counts as slow
+}
+
+// OutputStats counts what happened to one broadcast output.
+type OutputStats struct {
+	Sent         uint64 // Values handed to the output
+	Dropped      uint64 // Values lost to DropNewest or DropOldest
+	Lag          int    // Values buffered and not yet read
+	MaxLag       int    // Highest Lag seen
+	Disconnected bool
+}
+
+// Broadcaster copies every value of its input to all of its outputs.
+type Broadcaster[T any] struct {
+	outputs []*broadcastOutput[T]
+}
+
+type broadcastOutput[T any] struct {
+	cfg          OutputConfig
+	ch           chan T
+	sent         atomic.Uint64
+	dropped      atomic.Uint64
+	maxLag       atomic.Int64
+	disconnected atomic.Bool
+}
+
+// Broadcast starts copying in to one output per config. Outputs are closed
+// when in is closed or ctx is cancelled; a disconnected output is closed early.
+func Broadcast[T any](ctx context.Context, in <-chan T, configs ...OutputConfig) *Broadcaster[T] {
+	b := &Broadcaster[T]{outputs: make([]*broadcastOutput[T], len(configs))}
+	for i, cfg := range configs {
+		if cfg.Buffer < 0 {
+			cfg.Buffer = 0
+		}
+		b.outputs[i] = &broadcastOutput[T]{cfg: cfg, ch: make(chan T, cfg.Buffer)}
+	}
+
+	go func() {
+		defer func() {
+			for _, o := range b.outputs {
+				if !o.disconnected.Load() {
+					close(o.ch)
+				}
+			}
+		}()
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				return
+			}
+			for _, o := range b.outputs {
+				if !o.offer(ctx, v) {
+					return
+				}
+			}
+		}
+	}()
+
+	return b
+}
+
+// offer hands v to the output according to its policy. It returns false only
+// if ctx was cancelled while blocking.
+func (o *broadcastOutput[T]) offer(ctx context.Context, v T) bool {
+	if o.disconnected.Load() {
+		return true
+	}
+
+	policy := o.cfg.Policy
+	if policy == DropOldest && cap(o.ch) == 0 {
+		policy = DropNewest // Nothing buffered that could be dropped
+	}
+
+	switch policy {
+	case Block:
+		if !send(ctx, o.ch, v) {
+			return false
+		}
+	case DropNewest:
+		if !trySend(ctx, o.ch, v) {
+			o.dropped.Add(1)
+			return true
+		}
+	case DropOldest:
+		for !trySend(ctx, o.ch, v) {
+			// Make room; the reader may have done so already
+			select {
+			case <-o.ch:
+				o.dropped.Add(1)
+			default:
+			}
+		}
+	case Disconnect:
+		if !trySend(ctx, o.ch, v) {
+			o.disconnected.Store(true)
+			close(o.ch)
+			return true
+		}
+	}
+
+	o.sent.Add(1)
+	if lag := int64(len(o.ch)); lag > o.maxLag.Load() {
+		o.maxLag.Store(lag) // Only the broadcast goroutine writes maxLag
+	}
+	return true
+}
+
+// Out returns output i.
+func (b *Broadcaster[T]) Out(i int) <-chan T {
+	return b.outputs[i].ch
+}
+
+// Outs returns every output, in config order.
+func (b *Broadcaster[T]) Outs() []<-chan T {
+	outs := make([]<-chan T, len(b.outputs))
+	for i, o := range b.outputs {
+		outs[i] = o.ch
+	}
+	return outs
+}
+
+// Stats returns the counters of output i.
+func (b *Broadcaster[T]) Stats(i int) OutputStats {
+	o := b.outputs[i]
+	return OutputStats{
+		Sent:         o.sent.Load(),
+		Dropped:      o.dropped.Load(),
+		Lag:          len(o.ch),
+		MaxLag:       int(o.maxLag.Load()),
+		Disconnected: o.disconnected.Load(),
+	}
+}
@@ -0,0 +1,69 @@
+// Command pipeline runs pipelines described in JSON or YAML files.
+//
+//	pipeline run file.json       validate and run a pipeline, then print a summary
+//	pipeline validate file.yaml  only check the definition
+//	pipeline types               list the source, stage and sink types
+//
+// See the examples directory and the spec package for the file format.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline/spec"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pipeline run|validate <file.json|file.yaml>")
+	fmt.Fprintln(os.Stderr, "       pipeline types")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	reg := spec.Builtins()
+
+	switch cmd := os.Args[1]; cmd {
+	case "types":
+		for _, kind := range []spec.Kind{spec.KindSource, spec.KindStage, spec.KindSink} {
+			fmt.Printf("%-7s %s\n", kind+"s", strings.Join(reg.Types(kind), ", "))
+		}
+
+	case "run", "validate":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		def, err := spec.Load(os.Args[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := def.Validate(reg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if cmd == "validate" {
+			fmt.Printf("%s: ok (%d sources, %d stages, %d sinks)\n", os.Args[2], len(def.Sources), len(def.Stages), len(def.Sinks))
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		summary, err := spec.Run(ctx, def, reg)
+		fmt.Println()
+		summary.Print(os.Stdout)
+		if err != nil {
+			os.Exit(1)
+		}
+
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DeadlineError is returned for a job that did not finish within its deadline.
+type DeadlineError struct {
+	Timeout time.Duration
+	Job     any // The input that ran out of time
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("pipeline: job exceeded its %v deadline", e.Timeout)
+}
+
+func (e *DeadlineError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Deadline wraps a fallible worker so every job runs under its own context,
+// which expires after timeout. The job fails with a *DeadlineError as soon as
+// the deadline passes, even if fn ignores its context: the worker moves on to
+// the next job and whatever fn eventually returns is discarded.
+//
+// An abandoned fn keeps running until it returns, so a pool of n workers can
+// have more than n calls running at once, without limit if fn ignores its
+// context and hangs. Only the workers are bounded, not the calls.
+func Deadline[In, Out any](timeout time.Duration, fn func(ctx context.Context, worker int, v In) (Out, error)) func(ctx context.Context, worker int, v In) (Out, error) {
+	return DeadlineEach(func(In) time.Duration { return timeout }, fn)
+}
+
+// DeadlineEach is Deadline with the timeout chosen per job. A timeout of zero
+// or less leaves the job without a deadline of its own.
+func DeadlineEach[In, Out any](timeoutFor func(In) time.Duration, fn func(ctx context.Context, worker int, v In) (Out, error)) func(ctx context.Context, worker int, v In) (Out, error) {
+	return func(ctx context.Context, worker int, v In) (Out, error) {
+		timeout := timeoutFor(v)
+		if timeout <= 0 {
+			return safeCall(ctx, worker, v, fn)
+		}
+		return within(ctx, timeout, v, func(ctx context.Context) (Out, error) {
+			return safeCall(ctx, worker, v, fn)
+		})
+	}
+}
+
+// within runs fn, working on job, under a context that expires after timeout.
+func within[Out any](ctx context.Context, timeout time.Duration, job any, fn func(context.Context) (Out, error)) (Out, error) {
+	jobCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out, err := abandon(jobCtx, fn)
+	if err != nil && ctx.Err() == nil && jobCtx.Err() != nil {
+		// The deadline passed, rather than the whole pipeline being cancelled
+		return out, &DeadlineError{Timeout: timeout, Job: job}
+	}
+	return out, err
+}
+
+// abandon returns fn's result, or ctx.Err() as soon as ctx is done if fn has
+// not returned by then. fn is left running in the background.
+func abandon[Out any](ctx context.Context, fn func(context.Context) (Out, error)) (Out, error) {
+	type result struct {
+		out Out
+		err error
+	}
+	done := make(chan result, 1) // Never blocks an abandoned fn
+	go func() {
+		out, err := fn(ctx)
+		done <- result{out, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-ctx.Done():
+		var zero Out
+		return zero, ctx.Err()
+	}
+}
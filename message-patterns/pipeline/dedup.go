@@ -0,0 +1,372 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DedupConfig tunes a Dedup stage.
+type DedupConfig[T any] struct {
+	// Key identifies a value; values with the same key are duplicates.
+	Key func(T) string
+	// TTL is how long after a key was last seen a value with it is still a
+	// duplicate. Zero remembers keys until they are evicted.
+	TTL time.Duration
+	// Capacity bounds the keys remembered exactly. The least recently seen
+	// key is evicted first. Defaults to 10000.
+	Capacity int
+
+	// BloomKeys, if set, adds a Bloom filter sized for this many keys, which
+	// remembers keys after the exact set has evicted them. It can mistake a
+	// new key for a duplicate, at about BloomFalsePositives (default 0.01).
+	// The filter is replaced every TTL, or when full, keeping the previous one
+	// for lookups, so a key stays in it for between one and two TTLs.
+	BloomKeys           int
+	BloomFalsePositives float64
+
+	// Path, if set, is where the remembered keys are saved, so that a
+	// restarted stage keeps dropping duplicates of what it saw before. They
+	// are loaded when the stage starts and saved when its input ends, and
+	// every SaveEvery while it runs if that is set.
+	Path      string
+	SaveEvery time.Duration
+	// OnSaveError, if set, is called when saving fails.
+	OnSaveError func(error)
+
+	// OnDuplicate, if set, is called for every value dropped.
+	OnDuplicate func(T)
+}
+
+// DedupStats counts what a Dedup stage has seen.
+type DedupStats struct {
+	Hits      uint64 // Duplicates dropped
+	BloomHits uint64 // Of the Hits, those caught only by the Bloom filter
+	Misses    uint64 // Values passed on
+	Evictions uint64 // Keys evicted from the exact set to stay within Capacity
+	Size      int    // Keys remembered exactly
+}
+
+// Deduper drops values whose key was seen recently.
+type Deduper[T any] struct {
+	cfg DedupConfig[T]
+	out chan T
+
+	mu      sync.Mutex
+	stats   DedupStats
+	order   *list.List // Of *dedupEntry, most recently seen first
+	entries map[string]*list.Element
+	bloom   *rotatingBloom
+}
+
+type dedupEntry struct {
+	Key  string    `json:"key"`
+	Seen time.Time `json:"seen"`
+}
+
+// Dedup passes on the values of in whose key was not seen within the TTL.
+// Its output is closed once in is drained or ctx is cancelled.
+func Dedup[T any](ctx context.Context, in <-chan T, cfg DedupConfig[T]) *Deduper[T] {
+	if cfg.Capacity < 1 {
+		cfg.Capacity = 10000
+	}
+	if cfg.BloomFalsePositives <= 0 || cfg.BloomFalsePositives >= 1 {
+		cfg.BloomFalsePositives = 0.01
+	}
+
+	d := &Deduper[T]{
+		cfg:     cfg,
+		out:     make(chan T),
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if cfg.BloomKeys > 0 {
+		d.bloom = newRotatingBloom(cfg.BloomKeys, cfg.BloomFalsePositives, cfg.TTL)
+	}
+	if cfg.Path != "" {
+		if err := d.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			d.saveError(err)
+		}
+	}
+
+	go d.run(ctx, in)
+	return d
+}
+
+// Out returns the stream of values seen for the first time.
+func (d *Deduper[T]) Out() <-chan T {
+	return d.out
+}
+
+// Stats returns a snapshot of the counters.
+func (d *Deduper[T]) Stats() DedupStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := d.stats
+	stats.Size = d.order.Len()
+	return stats
+}
+
+func (d *Deduper[T]) run(ctx context.Context, in <-chan T) {
+	defer close(d.out)
+	if d.cfg.Path != "" {
+		defer d.save()
+	}
+
+	var saveTick <-chan time.Time
+	if d.cfg.Path != "" && d.cfg.SaveEvery > 0 {
+		ticker := time.NewTicker(d.cfg.SaveEvery)
+		defer ticker.Stop()
+		saveTick = ticker.C
+	}
+
+	for {
+		v, ok, fired := receiveUntil(ctx, in, saveTick)
+		if fired {
+			d.save()
+			continue
+		}
+		if !ok {
+			return
+		}
+		now := time.Now()
+		duplicate := d.seen(d.cfg.Key(v), now)
+		observe(ctx, now)
+		if !duplicate {
+			if !send(ctx, d.out, v) {
+				return
+			}
+		} else if d.cfg.OnDuplicate != nil {
+			d.cfg.OnDuplicate(v)
+		}
+	}
+}
+
+// seen records key and reports whether it is a duplicate.
+func (d *Deduper[T]) seen(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if el, ok := d.entries[key]; ok {
+		entry := el.Value.(*dedupEntry)
+		fresh := d.cfg.TTL <= 0 || now.Sub(entry.Seen) < d.cfg.TTL
+		entry.Seen = now
+		d.order.MoveToFront(el)
+		if d.bloom != nil {
+			d.bloom.add(key, now)
+		}
+		if fresh {
+			d.stats.Hits++
+			return true
+		}
+		// Known exactly to have expired, whatever the Bloom filter says
+		d.stats.Misses++
+		return false
+	}
+
+	duplicate := d.bloom != nil && d.bloom.contains(key, now)
+	d.remember(key, now, now)
+	if duplicate {
+		d.stats.Hits++
+		d.stats.BloomHits++
+		return true
+	}
+	d.stats.Misses++
+	return false
+}
+
+// remember adds a key last seen at seen to the exact set, evicting as needed.
+// Callers must hold d.mu.
+func (d *Deduper[T]) remember(key string, seen, now time.Time) {
+	d.entries[key] = d.order.PushFront(&dedupEntry{Key: key, Seen: seen})
+	if d.bloom != nil {
+		d.bloom.add(key, now)
+	}
+	for d.order.Len() > d.cfg.Capacity {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*dedupEntry).Key)
+		d.stats.Evictions++
+	}
+}
+
+// load restores the keys saved by a previous run, skipping expired ones.
+func (d *Deduper[T]) load() error {
+	data, err := os.ReadFile(d.cfg.Path)
+	if err != nil {
+		return err
+	}
+	var saved []dedupEntry // Most recently seen first
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for i := len(saved) - 1; i >= 0; i-- {
+		entry := saved[i]
+		if d.cfg.TTL > 0 && now.Sub(entry.Seen) >= d.cfg.TTL {
+			continue
+		}
+		if _, dup := d.entries[entry.Key]; !dup {
+			d.remember(entry.Key, entry.Seen, now)
+		}
+	}
+	return nil
+}
+
+// save writes the remembered keys to a temporary file and renames it over
+// Path, so a crash mid-write leaves the previous copy intact. The file is
+// synced before the rename and the directory after it, so that a power loss
+// cannot leave an empty file or undo the rename.
+func (d *Deduper[T]) save() {
+	d.mu.Lock()
+	saved := make([]dedupEntry, 0, d.order.Len())
+	for el := d.order.Front(); el != nil; el = el.Next() {
+		saved = append(saved, *el.Value.(*dedupEntry))
+	}
+	d.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		d.saveError(err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.cfg.Path), filepath.Base(d.cfg.Path)+".*")
+	if err != nil {
+		d.saveError(err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.cfg.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		d.saveError(err)
+		return
+	}
+	if err := syncDir(filepath.Dir(d.cfg.Path)); err != nil {
+		d.saveError(err)
+	}
+}
+
+// syncDir makes a rename in dir durable. Windows cannot sync a directory, and
+// commits renames with the file system's own journal instead.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d *Deduper[T]) saveError(err error) {
+	if d.cfg.OnSaveError != nil {
+		d.cfg.OnSaveError(err)
+	}
+}
+
+// rotatingBloom is a pair of Bloom filters: keys are added to the current
+// one, and looked up in both. When the current one is older than ttl, or
+// full, it becomes the previous one and a fresh filter takes its place.
+type rotatingBloom struct {
+	keys              int
+	falsePositives    float64
+	ttl               time.Duration
+	current, previous *bloomFilter
+	started           time.Time
+}
+
+func newRotatingBloom(keys int, falsePositives float64, ttl time.Duration) *rotatingBloom {
+	return &rotatingBloom{
+		keys:           keys,
+		falsePositives: falsePositives,
+		ttl:            ttl,
+		current:        newBloomFilter(keys, falsePositives),
+	}
+}
+
+func (r *rotatingBloom) rotate(now time.Time) {
+	if r.started.IsZero() {
+		r.started = now
+	}
+	if (r.ttl > 0 && now.Sub(r.started) >= r.ttl) || r.current.count >= r.keys {
+		r.previous, r.current = r.current, newBloomFilter(r.keys, r.falsePositives)
+		r.started = now
+	}
+}
+
+func (r *rotatingBloom) add(key string, now time.Time) {
+	r.rotate(now)
+	if !r.current.contains(key) {
+		r.current.add(key) // Only count distinct keys towards filling it
+	}
+}
+
+func (r *rotatingBloom) contains(key string, now time.Time) bool {
+	r.rotate(now)
+	return r.current.contains(key) || (r.previous != nil && r.previous.contains(key))
+}
+
+// bloomFilter is a classic Bloom filter using double hashing.
+type bloomFilter struct {
+	bits  []uint64
+	m     uint64 // Number of bits
+	k     int    // Number of hash functions
+	count int    // Keys added
+}
+
+func newBloomFilter(keys int, falsePositives float64) *bloomFilter {
+	m := math.Ceil(-float64(keys) * math.Log(falsePositives) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(keys) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, (uint64(m)+63)/64), m: uint64(m), k: k}
+}
+
+// positions derives the k bit positions of a key from two halves of its hash.
+func (b *bloomFilter) positions(key string, fn func(bit uint64)) {
+	h := hashKey(key)
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := 0; i < b.k; i++ {
+		fn((h1 + uint64(i)*h2) % b.m)
+	}
+}
+
+func (b *bloomFilter) add(key string) {
+	b.positions(key, func(bit uint64) { b.bits[bit/64] |= 1 << (bit % 64) })
+	b.count++
+}
+
+func (b *bloomFilter) contains(key string) bool {
+	found := true
+	b.positions(key, func(bit uint64) {
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
@@ -0,0 +1,37 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDedupStateSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cfg := DedupConfig[string]{
+		Key:         func(s string) string { return s },
+		Path:        filepath.Join(dir, "seen.json"),
+		OnSaveError: func(err error) { t.Errorf("saving: %v", err) },
+	}
+
+	first, err := Collect(ctx, Dedup(ctx, FromSlice(ctx, "a", "b", "a"), cfg).Out())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Collect(ctx, Dedup(ctx, FromSlice(ctx, "b", "c"), cfg).Out())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(first, []string{"a", "b"}) || !slices.Equal(second, []string{"c"}) {
+		t.Fatalf("passed %v then %v, want [a b] then [c]", first, second)
+	}
+
+	// Only the state file is left, no temporary copies
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "seen.json" {
+		t.Fatalf("directory holds %v, want only seen.json", entries)
+	}
+}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// Outcome is what a fallible worker produced for one input: a value, or the
+// error it failed with.
+type Outcome[T any] struct {
+	Value T
+	Err   error
+}
+
+// FailurePolicy decides when worker errors abort a run.
+type FailurePolicy struct {
+	// MaxErrors aborts the run once this many errors have been reported.
+	// Zero never aborts.
+	MaxErrors int
+}
+
+var (
+	// FailFast cancels everything on the first error, like errgroup.
+	FailFast = FailurePolicy{MaxErrors: 1}
+	// CollectAll never aborts; errors are gathered alongside the results.
+	CollectAll = FailurePolicy{}
+)
+
+// AbortAfter cancels the run when the n-th error is reported.
+func AbortAfter(n int) FailurePolicy {
+	return FailurePolicy{MaxErrors: n}
+}
+
+// PanicError is a worker panic turned into an error.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("pipeline: worker panicked: %v", e.Value)
+}
+
+// ThresholdError is the cause of a run aborted by a FailurePolicy with
+// MaxErrors above one.
+type ThresholdError struct {
+	Errors []error
+}
+
+func (e *ThresholdError) Error() string {
+	return fmt.Sprintf("pipeline: aborted after %d errors: %v", len(e.Errors), errors.Join(e.Errors...))
+}
+
+func (e *ThresholdError) Unwrap() []error {
+	return e.Errors
+}
+
+// ErrorGroup collects worker errors and applies a FailurePolicy.
+type ErrorGroup struct {
+	policy FailurePolicy
+	cancel context.CancelCauseFunc
+
+	mu      sync.Mutex
+	errs    []error
+	aborted error
+}
+
+// WithFailurePolicy returns an ErrorGroup and a context that is cancelled when
+// the policy aborts the run. Stages must be started with the returned context
+// so they stop together. Call stop once the run is over, like the cancel func
+// of context.WithCancel, to release the context.
+func WithFailurePolicy(ctx context.Context, policy FailurePolicy) (g *ErrorGroup, runCtx context.Context, stop context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &ErrorGroup{policy: policy, cancel: cancel}, ctx, func() { cancel(nil) }
+}
+
+// Report records a worker error and aborts the run if the policy says so.
+// It returns false once the run is aborted.
+func (g *ErrorGroup) Report(err error) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.aborted != nil {
+		return false
+	}
+	g.errs = append(g.errs, err)
+	if g.policy.MaxErrors == 0 || len(g.errs) < g.policy.MaxErrors {
+		return true
+	}
+
+	if g.policy.MaxErrors == 1 {
+		g.aborted = err
+	} else {
+		g.aborted = &ThresholdError{Errors: append([]error(nil), g.errs...)}
+	}
+	g.cancel(g.aborted)
+	return false
+}
+
+// Errors returns every error reported so far.
+func (g *ErrorGroup) Errors() []error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]error(nil), g.errs...)
+}
+
+// Err returns the error that aborted the run. If the run was not aborted, it
+// returns all reported errors joined, or nil if there were none.
+func (g *ErrorGroup) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.aborted != nil {
+		return g.aborted
+	}
+	return errors.Join(g.errs...)
+}
+
+// Fallible adapts a worker that returns (Out, error) for use with FanOut and
+// OrderedFanOut. Errors, including recovered panics, are reported to g (which
+// may be nil) and returned in the Outcome.
+func Fallible[In, Out any](g *ErrorGroup, fn func(ctx context.Context, worker int, v In) (Out, error)) func(ctx context.Context, worker int, v In) Outcome[Out] {
+	return func(ctx context.Context, worker int, v In) Outcome[Out] {
+		out, err := safeCall(ctx, worker, v, fn)
+		if err != nil && g != nil {
+			g.Report(err)
+		}
+		return Outcome[Out]{Value: out, Err: err}
+	}
+}
+
+// FanOutErr is FanOut for workers that can fail.
+func FanOutErr[In, Out any](ctx context.Context, g *ErrorGroup, in <-chan In, n int, fn func(ctx context.Context, worker int, v In) (Out, error)) []<-chan Outcome[Out] {
+	return FanOut(ctx, in, n, Fallible(g, fn))
+}
+
+// safeCall runs fn, turning a panic into a *PanicError.
+func safeCall[In, Out any](ctx context.Context, worker int, v In, fn func(context.Context, int, In) (Out, error)) (out Out, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return fn(ctx, worker, v)
+}
@@ -0,0 +1,295 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgeConfig tunes a HedgedPool.
+type HedgeConfig struct {
+	// Timeout is each job's deadline, shared by all of its attempts and
+	// counted from when a worker takes the job. When it passes, the context
+	// of the attempts is cancelled with a *DeadlineError as its cause. Zero
+	// leaves jobs without a deadline of their own.
+	Timeout time.Duration
+
+	// Hedge starts a duplicate attempt on another worker for any job still
+	// running after the Percentile latency of recent jobs, measured from when
+	// a worker took each job until its first attempt finished. The first attempt
+	// to finish wins and the other is cancelled.
+	Hedge      bool
+	Percentile float64 // Defaults to 0.95
+	MinSamples int     // Jobs to observe before hedging starts; defaults to 20
+	Window     int     // Recent latencies the percentile is taken over; defaults to 200
+}
+
+// HedgeStats is a snapshot of a HedgedPool.
+type HedgeStats struct {
+	Jobs      uint64        // Jobs finished, successfully or not
+	Hedged    uint64        // Jobs that were given a second attempt
+	HedgeWins uint64        // Hedged jobs where the second attempt finished first
+	TimedOut  uint64        // Jobs that failed with a *DeadlineError
+	Threshold time.Duration // Current hedging latency; zero until MinSamples jobs finished
+}
+
+// HedgedPool runs each job under its own deadline, optionally hedging slow
+// ones, and emits one Outcome per job in completion order.
+type HedgedPool[In, Out any] struct {
+	cfg       HedgeConfig
+	g         *ErrorGroup
+	dlq       *DeadLetterQueue[In]
+	fn        func(ctx context.Context, worker int, v In) (Out, error)
+	out       chan Outcome[Out]
+	primaries chan hedgeAttempt[In, Out]
+	hedges    chan hedgeAttempt[In, Out] // Taken by workers ahead of new jobs
+
+	mu        sync.Mutex
+	stats     HedgeStats
+	latencies []time.Duration // Ring buffer of recent job latencies
+	next      int
+}
+
+// hedgedJob is one job and the attempts racing to finish it.
+type hedgedJob[In, Out any] struct {
+	v        In
+	ctx      context.Context // Cancelled at the job's deadline, or once it is done
+	cancel   context.CancelCauseFunc
+	started  time.Time   // When a worker took the job
+	deadline *time.Timer // Cancels ctx with a *DeadlineError
+	once     sync.Once
+	done     chan struct{}
+	result   Outcome[Out]
+	hedged   bool // Result came from the second attempt
+	finished time.Time
+}
+
+type hedgeAttempt[In, Out any] struct {
+	job   *hedgedJob[In, Out]
+	hedge bool
+}
+
+// finish records the first attempt to return, ignoring the rest.
+func (j *hedgedJob[In, Out]) finish(out Out, err error, hedge bool) {
+	j.once.Do(func() {
+		j.result, j.hedged, j.finished = Outcome[Out]{Value: out, Err: err}, hedge, time.Now()
+		close(j.done)
+	})
+}
+
+// Hedged starts n workers running fn on the values of in. Failed jobs,
+// including timed out ones, are reported to g (which may be nil), and jobs
+// that time out are added to dlq (which may be nil) as well. A worker
+// stuck in fn past the job's deadline, or on the losing side of a hedge, is
+// released as soon as the job's context is done; fn keeps running on its own
+// until it returns, and its result is discarded. Those calls are not counted
+// against n, so with fn ignoring its context there can be any number running.
+func Hedged[In, Out any](ctx context.Context, g *ErrorGroup, dlq *DeadLetterQueue[In], in <-chan In, n int, cfg HedgeConfig, fn func(ctx context.Context, worker int, v In) (Out, error)) *HedgedPool[In, Out] {
+	if n < 1 {
+		n = 1
+	}
+	if cfg.Percentile <= 0 || cfg.Percentile >= 1 {
+		cfg.Percentile = 0.95
+	}
+	if cfg.MinSamples < 1 {
+		cfg.MinSamples = 20
+	}
+	if cfg.Window < cfg.MinSamples {
+		cfg.Window = max(200, cfg.MinSamples)
+	}
+
+	p := &HedgedPool[In, Out]{
+		cfg:       cfg,
+		g:         g,
+		dlq:       dlq,
+		fn:        fn,
+		out:       make(chan Outcome[Out]),
+		primaries: make(chan hedgeAttempt[In, Out]),
+		hedges:    make(chan hedgeAttempt[In, Out]),
+	}
+
+	for i := 1; i <= n; i++ {
+		go p.work(ctx, i)
+	}
+	go p.dispatch(ctx, in)
+
+	return p
+}
+
+// Out returns the stream of outcomes.
+func (p *HedgedPool[In, Out]) Out() <-chan Outcome[Out] {
+	return p.out
+}
+
+// Stats returns a snapshot of the pool's counters.
+func (p *HedgedPool[In, Out]) Stats() HedgeStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Threshold, _ = p.threshold()
+	return stats
+}
+
+// dispatch hands each job to a worker and starts a goroutine to see it
+// through. The output is closed once every job has an outcome.
+func (p *HedgedPool[In, Out]) dispatch(ctx context.Context, in <-chan In) {
+	var wg sync.WaitGroup
+	defer close(p.out)
+	defer wg.Wait()
+	defer close(p.primaries)
+
+	for {
+		v, ok := receive(ctx, in)
+		if !ok {
+			return
+		}
+
+		job := &hedgedJob[In, Out]{v: v, done: make(chan struct{})}
+		job.ctx, job.cancel = context.WithCancelCause(ctx)
+		if !send(unobserved(ctx), p.primaries, hedgeAttempt[In, Out]{job: job}) {
+			job.cancel(nil)
+			return
+		}
+
+		// A worker has the job now, so its clock starts, not while it queued
+		job.started = time.Now()
+		if p.cfg.Timeout > 0 {
+			expired := &DeadlineError{Timeout: p.cfg.Timeout, Job: v}
+			job.deadline = time.AfterFunc(p.cfg.Timeout, func() { job.cancel(expired) })
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.watch(ctx, job)
+		}()
+	}
+}
+
+// watch waits for a job's outcome, starting a hedge if it runs long.
+func (p *HedgedPool[In, Out]) watch(ctx context.Context, job *hedgedJob[In, Out]) {
+	defer job.cancel(nil)
+	if job.deadline != nil {
+		defer job.deadline.Stop()
+	}
+
+	var hedgeAt <-chan time.Time
+	if p.cfg.Hedge {
+		p.mu.Lock()
+		threshold, ok := p.threshold()
+		p.mu.Unlock()
+		if ok {
+			timer := time.NewTimer(threshold)
+			defer timer.Stop()
+			hedgeAt = timer.C
+		}
+	}
+
+	var hedges chan<- hedgeAttempt[In, Out] // Set while a hedge waits for a worker
+	for {
+		select {
+		case <-job.done:
+			p.mu.Lock()
+			p.stats.Jobs++
+			if job.hedged {
+				p.stats.HedgeWins++
+			}
+			if job.result.Err == nil {
+				p.record(job.finished.Sub(job.started))
+			}
+			p.mu.Unlock()
+			p.emit(ctx, job.result)
+			return
+
+		case <-job.ctx.Done():
+			if ctx.Err() != nil {
+				return // The pipeline is cancelled, not just this job
+			}
+			err := context.Cause(job.ctx)
+			p.mu.Lock()
+			p.stats.Jobs++
+			p.stats.TimedOut++
+			p.mu.Unlock()
+			if p.dlq != nil {
+				p.dlq.Add(DeadLetter[In]{Job: job.v, Attempts: []Attempt{{Number: 1, At: time.Now(), Err: err}}})
+			}
+			p.emit(ctx, Outcome[Out]{Err: err})
+			return
+
+		case <-hedgeAt:
+			hedgeAt = nil
+			hedges = p.hedges
+			p.mu.Lock()
+			p.stats.Hedged++
+			p.mu.Unlock()
+
+		case hedges <- hedgeAttempt[In, Out]{job: job, hedge: true}:
+			hedges = nil
+		}
+	}
+}
+
+func (p *HedgedPool[In, Out]) emit(ctx context.Context, outcome Outcome[Out]) {
+	if outcome.Err != nil && p.g != nil {
+		p.g.Report(outcome.Err)
+	}
+	send(ctx, p.out, outcome)
+}
+
+// work runs attempts, taking pending hedges before new jobs so a slow job's
+// duplicate does not queue behind them.
+func (p *HedgedPool[In, Out]) work(ctx context.Context, worker int) {
+	for {
+		var a hedgeAttempt[In, Out]
+		select {
+		case a = <-p.hedges:
+		default:
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case a = <-p.hedges:
+			case a, ok = <-p.primaries:
+				if !ok {
+					return
+				}
+			}
+		}
+
+		job := a.job
+		if job.ctx.Err() != nil {
+			continue // Already finished by the other attempt, or timed out
+		}
+		start := time.Now()
+		out, err := abandon(job.ctx, func(ctx context.Context) (Out, error) {
+			return safeCall(ctx, worker, job.v, p.fn)
+		})
+		observe(ctx, start)
+		if job.ctx.Err() != nil && err != nil {
+			continue // Lost the race, or ran out of time; watch reports it
+		}
+		job.finish(out, err, a.hedge)
+	}
+}
+
+// record adds a finished job's latency. Callers must hold p.mu.
+func (p *HedgedPool[In, Out]) record(d time.Duration) {
+	if len(p.latencies) < p.cfg.Window {
+		p.latencies = append(p.latencies, d)
+		return
+	}
+	p.latencies[p.next] = d
+	p.next = (p.next + 1) % p.cfg.Window
+}
+
+// threshold returns the hedging latency, once there are enough samples.
+// Callers must hold p.mu.
+func (p *HedgedPool[In, Out]) threshold() (time.Duration, bool) {
+	if len(p.latencies) < p.cfg.MinSamples {
+		return 0, false
+	}
+	sorted := append([]time.Duration(nil), p.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p.cfg.Percentile*float64(len(sorted)-1))], true
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHedgedTimeoutIsDeadLettered(t *testing.T) {
+	ctx := context.Background()
+	dlq := NewDeadLetterQueue[int]()
+	pool := Hedged(ctx, nil, dlq, FromSlice(ctx, 7), 1, HedgeConfig{Timeout: 20 * time.Millisecond},
+		func(ctx context.Context, _ int, v int) (int, error) {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		})
+
+	outcomes, err := Collect(ctx, pool.Out())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deadline *DeadlineError
+	if len(outcomes) != 1 || !errors.As(outcomes[0].Err, &deadline) || deadline.Job != 7 {
+		t.Fatalf("outcomes = %+v, want one *DeadlineError for job 7", outcomes)
+	}
+	letters := dlq.Letters()
+	if len(letters) != 1 || letters[0].Job != 7 || !errors.Is(letters[0].Err(), context.DeadlineExceeded) {
+		t.Fatalf("dead letters = %+v, want job 7 with its deadline error", letters)
+	}
+}
+
+func TestHedgedDeadlineStartsWhenTaken(t *testing.T) {
+	ctx := context.Background()
+
+	// One worker, so each job waits for the one before it. Every job fits
+	// its deadline, but not with the wait counted against it.
+	const took, timeout = 60 * time.Millisecond, 100 * time.Millisecond
+	pool := Hedged(ctx, nil, nil, FromSlice(ctx, 1, 2, 3, 4), 1, HedgeConfig{Timeout: timeout, MinSamples: 4},
+		func(ctx context.Context, _ int, v int) (int, error) {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(took):
+				return v, nil
+			}
+		})
+
+	outcomes, err := Collect(ctx, pool.Out())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range outcomes {
+		if o.Err != nil {
+			t.Fatalf("job failed: %v", o.Err)
+		}
+	}
+	if threshold := pool.Stats().Threshold; threshold < took || threshold >= timeout {
+		t.Fatalf("latency threshold %v, want about the %v each job took", threshold, took)
+	}
+}
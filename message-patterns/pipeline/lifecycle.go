@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrGraceExpired is returned by Lifecycle.Shutdown when the pipeline did not
+// drain within the grace period and had to be cancelled.
+var ErrGraceExpired = errors.New("pipeline: shutdown grace period expired, in-flight work cancelled")
+
+// Lifecycle gives a pipeline a two-phase shutdown. Sources are started with
+// SourceContext and every other stage with Context. Shutdown first stops the
+// sources only, so their outputs close and the rest of the pipeline drains the
+// values already in flight; whatever is still running after the grace period
+// is cancelled.
+type Lifecycle struct {
+	sources     context.Context
+	stopSources context.CancelFunc
+	work        context.Context
+	cancelWork  context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// NewLifecycle derives the source and work contexts from parent. Cancelling
+// parent still stops everything at once.
+func NewLifecycle(parent context.Context) *Lifecycle {
+	work, cancelWork := context.WithCancel(parent)
+	sources, stopSources := context.WithCancel(work)
+	return &Lifecycle{
+		sources:     sources,
+		stopSources: stopSources,
+		work:        work,
+		cancelWork:  cancelWork,
+	}
+}
+
+// SourceContext is cancelled as soon as Shutdown starts.
+func (l *Lifecycle) SourceContext() context.Context {
+	return l.sources
+}
+
+// Context is cancelled only when the grace period of Shutdown expires.
+func (l *Lifecycle) Context() context.Context {
+	return l.work
+}
+
+// Go runs fn, typically the final Sink, and tracks it so Shutdown and Wait
+// know when the pipeline has drained.
+func (l *Lifecycle) Go(fn func()) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until every function started with Go has returned, then
+// releases the contexts.
+func (l *Lifecycle) Wait() {
+	l.wg.Wait()
+	l.cancelWork()
+}
+
+// Shutdown stops the sources and waits up to grace for the functions started
+// with Go to return. If they have not, the remaining work is cancelled and
+// Shutdown waits for them to exit before returning ErrGraceExpired.
+func (l *Lifecycle) Shutdown(grace time.Duration) error {
+	l.stopSources()
+
+	drained := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+		l.cancelWork()
+		return nil
+	case <-timer.C:
+		l.cancelWork()
+		<-drained
+		return ErrGraceExpired
+	}
+}
@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// checkGoroutines fails the test unless the goroutine count settles back to
+// baseline, giving stages that are shutting down a moment to return.
+func checkGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= baseline {
+			return
+		}
+		if time.Now().After(deadline) {
+			stacks := make([]byte, 1<<16)
+			stacks = stacks[:runtime.Stack(stacks, true)]
+			t.Fatalf("%d goroutines still running, want %d:\n%s", n, baseline, stacks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// counter emits 0, 1, 2, ... until ctx is cancelled.
+func counter(ctx context.Context) <-chan int {
+	return Source(ctx, func(i int) (int, bool) { return i, true })
+}
+
+func TestMergeDrained(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	ctx := context.Background()
+
+	merged := Merge(ctx, FromSlice(ctx, 1, 2, 3), FromSlice(ctx, 4, 5), FromSlice[int](ctx))
+	values, err := Collect(ctx, merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 5 {
+		t.Fatalf("got %v, want 5 values", values)
+	}
+	checkGoroutines(t, baseline)
+}
+
+func TestMergeCancelled(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The sources never end and the output is abandoned mid-stream
+	merged := Merge(ctx, counter(ctx), counter(ctx), counter(ctx))
+	for i := 0; i < 10; i++ {
+		<-merged
+	}
+	cancel()
+	checkGoroutines(t, baseline)
+}
+
+func TestShutdownDrained(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	life := NewLifecycle(context.Background())
+	ctx := life.Context()
+
+	squares := FanOut(ctx, counter(life.SourceContext()), 3, func(_ context.Context, _ int, v int) int {
+		return v * v
+	})
+	var sunk int
+	life.Go(func() {
+		Sink(ctx, Merge(ctx, squares...), func(int) { sunk++ })
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	if err := life.Shutdown(time.Second); err != nil {
+		t.Fatalf("Shutdown() = %v, want nil", err)
+	}
+	if sunk == 0 {
+		t.Fatal("nothing reached the sink")
+	}
+	checkGoroutines(t, baseline)
+}
+
+func TestShutdownGraceExpired(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	life := NewLifecycle(context.Background())
+	ctx := life.Context()
+
+	// Workers hang until cancelled, so the pipeline cannot drain in time
+	started := make(chan struct{}, 1)
+	hung := FanOut(ctx, counter(life.SourceContext()), 2, func(ctx context.Context, _ int, v int) int {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return v
+	})
+	life.Go(func() {
+		Sink(ctx, Merge(ctx, hung...), func(int) {})
+	})
+
+	<-started
+	if err := life.Shutdown(50 * time.Millisecond); !errors.Is(err, ErrGraceExpired) {
+		t.Fatalf("Shutdown() = %v, want ErrGraceExpired", err)
+	}
+	checkGoroutines(t, baseline)
+}
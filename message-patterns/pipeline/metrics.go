@@ -0,0 +1,400 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics collects per-stage statistics for a pipeline. A stage is observed
+// by passing it a context from Stage; every value it receives and sends, the
+// time it spends blocked doing so, and the time it spends in the stage's own
+// function are then counted under the stage's name. A nil *Metrics observes
+// nothing, so instrumentation can be switched off without changing the wiring.
+type Metrics struct {
+	mu     sync.Mutex
+	stages []*stageMetrics // In the order they were declared
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// Stage returns a context that attributes the work of the stage it is passed
+// to under name. inputs names the stages feeding this one, for Topology.
+// Calling Stage again with the same name adds to the same statistics.
+func (m *Metrics) Stage(ctx context.Context, name string, inputs ...string) context.Context {
+	if m == nil {
+		return ctx
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.stages {
+		if s.name == name {
+			s.addInputs(inputs)
+			return context.WithValue(ctx, stageKey{}, s)
+		}
+	}
+	s := &stageMetrics{name: name}
+	s.addInputs(inputs)
+	m.stages = append(m.stages, s)
+	return context.WithValue(ctx, stageKey{}, s)
+}
+
+// Stats returns a snapshot of every stage, in the order they were declared.
+func (m *Metrics) Stats() []StageStats {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	stages := append([]*stageMetrics(nil), m.stages...)
+	m.mu.Unlock()
+
+	stats := make([]StageStats, len(stages))
+	for i, s := range stages {
+		stats[i] = s.snapshot()
+	}
+	return stats
+}
+
+// StageStats is a snapshot of one stage. Durations are summed over all of
+// the stage's goroutines, so a stage with several workers can report more
+// busy time than has passed.
+type StageStats struct {
+	Name          string
+	Inputs        []string
+	In            uint64    // Values received
+	Out           uint64    // Values sent
+	Latency       Histogram // Time spent in the stage's function per value
+	QueueDepth    int       // Values waiting in the input channel at the last receive
+	MaxQueueDepth int
+	Busy          time.Duration // Total processing time
+	BlockedSend   time.Duration // Waiting for downstream to take a value
+	BlockedRecv   time.Duration // Waiting for upstream to provide a value
+}
+
+// Utilization is the share of the stage's time spent processing rather than
+// blocked on its channels.
+func (s StageStats) Utilization() float64 {
+	total := s.Busy + s.BlockedSend + s.BlockedRecv
+	if total <= 0 {
+		return 0
+	}
+	return float64(s.Busy) / float64(total)
+}
+
+// Histogram counts latencies in exponential buckets.
+type Histogram struct {
+	Bounds []time.Duration // Upper bound of each bucket; the last is unbounded
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// histogramBuckets is the number of buckets in a stage's latency histogram.
+const histogramBuckets = 26
+
+// histogramBounds step 1, 2, 5, 10, ... from 1µs to 100s.
+var histogramBounds = func() []time.Duration {
+	bounds := make([]time.Duration, histogramBuckets)
+	step := time.Microsecond
+	for i := 0; i < len(bounds)-1; i++ {
+		bounds[i] = step * []time.Duration{1, 2, 5}[i%3]
+		if i%3 == 2 {
+			step *= 10
+		}
+	}
+	bounds[len(bounds)-1] = math.MaxInt64
+	return bounds
+}()
+
+// Quantile returns an upper bound for the q-quantile, such as 0.99.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.Counts {
+		seen += n
+		if seen >= rank {
+			if i == len(h.Counts)-1 {
+				return h.Bounds[i-1] // Unbounded, report what is known
+			}
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-2]
+}
+
+// Mean returns the average latency.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+type stageKey struct{}
+
+// stageMetrics is updated by the stage's goroutines as it runs.
+type stageMetrics struct {
+	name string
+
+	mu     sync.Mutex
+	inputs []string
+
+	in, out      atomic.Uint64
+	blockedSend  atomic.Int64
+	blockedRecv  atomic.Int64
+	depth        atomic.Int64
+	maxDepth     atomic.Int64
+	latencies    [histogramBuckets]atomic.Uint64 // Indexed like histogramBounds
+	latencyCount atomic.Uint64
+	latencyTotal atomic.Int64
+}
+
+// stageOf returns the stage observing ctx, or nil.
+func stageOf(ctx context.Context) *stageMetrics {
+	s, _ := ctx.Value(stageKey{}).(*stageMetrics)
+	return s
+}
+
+// unobserved hides ctx's stage from the internal hops of a stage built from
+// others, so each value is only counted on its way in and out.
+func unobserved(ctx context.Context) context.Context {
+	if stageOf(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stageKey{}, (*stageMetrics)(nil))
+}
+
+// observe records the processing time of one value since start.
+func observe(ctx context.Context, start time.Time) {
+	if s := stageOf(ctx); s != nil {
+		s.processed(time.Since(start))
+	}
+}
+
+func (s *stageMetrics) addInputs(inputs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+outer:
+	for _, input := range inputs {
+		for _, known := range s.inputs {
+			if known == input {
+				continue outer
+			}
+		}
+		s.inputs = append(s.inputs, input)
+	}
+}
+
+// clock returns the current time, or the zero time when nothing is observed
+// so unobserved stages do not pay for reading the clock.
+func (s *stageMetrics) clock() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (s *stageMetrics) sendDone(start time.Time, sent bool) {
+	if s == nil {
+		return
+	}
+	s.blockedSend.Add(int64(time.Since(start)))
+	if sent {
+		s.out.Add(1)
+	}
+}
+
+func (s *stageMetrics) receiveDone(start time.Time, received bool) {
+	if s == nil {
+		return
+	}
+	s.blockedRecv.Add(int64(time.Since(start)))
+	if received {
+		s.in.Add(1)
+	}
+}
+
+// queued samples the number of values waiting in the stage's input.
+func (s *stageMetrics) queued(depth int) {
+	if s == nil {
+		return
+	}
+	s.depth.Store(int64(depth))
+	for {
+		peak := s.maxDepth.Load()
+		if int64(depth) <= peak || s.maxDepth.CompareAndSwap(peak, int64(depth)) {
+			return
+		}
+	}
+}
+
+func (s *stageMetrics) processed(d time.Duration) {
+	i := sort.Search(len(histogramBounds), func(i int) bool { return histogramBounds[i] >= d })
+	s.latencies[i].Add(1)
+	s.latencyCount.Add(1)
+	s.latencyTotal.Add(int64(d))
+}
+
+func (s *stageMetrics) snapshot() StageStats {
+	s.mu.Lock()
+	inputs := append([]string(nil), s.inputs...)
+	s.mu.Unlock()
+
+	h := Histogram{
+		Bounds: histogramBounds,
+		Counts: make([]uint64, len(histogramBounds)),
+		Count:  s.latencyCount.Load(),
+		Sum:    time.Duration(s.latencyTotal.Load()),
+	}
+	for i := range h.Counts {
+		h.Counts[i] = s.latencies[i].Load()
+	}
+
+	return StageStats{
+		Name:          s.name,
+		Inputs:        inputs,
+		In:            s.in.Load(),
+		Out:           s.out.Load(),
+		Latency:       h,
+		QueueDepth:    int(s.depth.Load()),
+		MaxQueueDepth: int(s.maxDepth.Load()),
+		Busy:          h.Sum,
+		BlockedSend:   time.Duration(s.blockedSend.Load()),
+		BlockedRecv:   time.Duration(s.blockedRecv.Load()),
+	}
+}
+
+// Topology renders the stage graph in Graphviz DOT, each stage labelled with
+// its current statistics and the bottleneck highlighted.
+func (m *Metrics) Topology() string {
+	stats := m.Stats()
+	bottleneck := findBottleneck(stats)
+
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=rounded, fontname=\"monospace\"];\n")
+	for _, s := range stats {
+		label := fmt.Sprintf("%s\\nin %d  out %d\\np50 %v  p99 %v\\nqueue %d (max %d)\\nblocked send %v  recv %v\\nbusy %.0f%%",
+			s.Name, s.In, s.Out,
+			s.Latency.Quantile(0.5), s.Latency.Quantile(0.99),
+			s.QueueDepth, s.MaxQueueDepth,
+			s.BlockedSend.Round(time.Millisecond), s.BlockedRecv.Round(time.Millisecond),
+			100*s.Utilization())
+		attrs := ""
+		if s.Name == bottleneck {
+			attrs = ", color=red, penwidth=2"
+		}
+		fmt.Fprintf(&b, "\t%s [label=\"%s\"%s];\n", dotID(s.Name), label, attrs)
+	}
+	for _, s := range stats {
+		for _, input := range s.Inputs {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", dotID(input), dotID(s.Name))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotID(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `\"`) + `"`
+}
+
+// BottleneckReport explains which stage limits a pipeline's throughput.
+type BottleneckReport struct {
+	Stages     []StageStats // Busiest first
+	Bottleneck string       // Empty if no stage processed anything
+	Reason     string
+}
+
+// Bottleneck reports the stage that spends the largest share of its time
+// processing rather than waiting on its neighbours. Stages upstream of a
+// bottleneck block on send, and stages downstream of it block on receive.
+func (m *Metrics) Bottleneck() BottleneckReport {
+	stats := m.Stats()
+	report := BottleneckReport{Bottleneck: findBottleneck(stats)}
+	report.Stages = append(report.Stages, stats...)
+	sort.SliceStable(report.Stages, func(i, j int) bool {
+		return report.Stages[i].Utilization() > report.Stages[j].Utilization()
+	})
+	if report.Bottleneck == "" {
+		return report
+	}
+
+	byName := make(map[string]StageStats, len(stats))
+	for _, s := range stats {
+		byName[s.Name] = s
+	}
+	b := byName[report.Bottleneck]
+	reason := fmt.Sprintf("%s is busy %.0f%% of the time (p99 %v per value)",
+		b.Name, 100*b.Utilization(), b.Latency.Quantile(0.99))
+	for _, input := range b.Inputs {
+		if up, ok := byName[input]; ok {
+			reason += fmt.Sprintf("; upstream %s spends %.0f%% blocked on send", up.Name, 100*share(up.BlockedSend, up))
+		}
+	}
+	for _, s := range stats {
+		for _, input := range s.Inputs {
+			if input == b.Name {
+				reason += fmt.Sprintf("; downstream %s spends %.0f%% blocked on receive", s.Name, 100*share(s.BlockedRecv, s))
+			}
+		}
+	}
+	report.Reason = reason
+	return report
+}
+
+// String formats the report as a table followed by the conclusion.
+func (r BottleneckReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-12s %8s %8s %6s %10s %10s %10s %10s\n", "STAGE", "IN", "OUT", "BUSY", "P50", "P99", "SEND WAIT", "RECV WAIT")
+	for _, s := range r.Stages {
+		fmt.Fprintf(&b, "%-12s %8d %8d %5.0f%% %10v %10v %9.0f%% %9.0f%%\n",
+			s.Name, s.In, s.Out, 100*s.Utilization(),
+			s.Latency.Quantile(0.5), s.Latency.Quantile(0.99),
+			100*share(s.BlockedSend, s), 100*share(s.BlockedRecv, s))
+	}
+	if r.Bottleneck == "" {
+		b.WriteString("No bottleneck: no stage reported processing time\n")
+	} else {
+		fmt.Fprintf(&b, "Bottleneck: %s\n", r.Reason)
+	}
+	return b.String()
+}
+
+// share is d as a fraction of everything the stage spent time on.
+func share(d time.Duration, s StageStats) float64 {
+	total := s.Busy + s.BlockedSend + s.BlockedRecv
+	if total <= 0 {
+		return 0
+	}
+	return float64(d) / float64(total)
+}
+
+func findBottleneck(stats []StageStats) string {
+	name, best := "", 0.0
+	for _, s := range stats {
+		if s.Latency.Count == 0 {
+			continue // Plumbing such as Merge does no processing of its own
+		}
+		if u := s.Utilization(); u > best {
+			name, best = s.Name, u
+		}
+	}
+	return name
+}
@@ -0,0 +1,54 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMetricsCountEveryStage(t *testing.T) {
+	ctx := context.Background()
+	m := NewMetrics()
+
+	if _, err := Collect(ctx, Batch(m.Stage(ctx, "batch"), FromSlice(ctx, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 4, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := MergeBy(m.Stage(ctx, "merge"), WeightedFair, MergeInput[string]{In: filled("a", 3)}, MergeInput[string]{In: filled("b", 2)})
+	if _, err := Collect(ctx, merged.Out()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A blocking output and a dropping one that has room for everything
+	b := Broadcast(m.Stage(ctx, "broadcast"), FromSlice(ctx, 1, 2, 3, 4, 5, 6),
+		OutputConfig{Policy: Block}, OutputConfig{Policy: DropNewest, Buffer: 6})
+	if _, err := Collect(ctx, b.Out(0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Collect(ctx, b.Out(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	windows := Aggregate(m.Stage(ctx, "window"), FromSlice(ctx, 1, 2, 3, 4, 5), WindowConfig[int]{
+		Spec:      Tumbling(10 * time.Second),
+		EventTime: func(v int) time.Time { return time.Unix(int64(v), 0) },
+	}, Count[int]())
+	if _, err := Collect(ctx, windows); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][2]uint64{
+		"batch":     {10, 3},
+		"merge":     {5, 5},
+		"broadcast": {6, 12},
+		"window":    {5, 1},
+	}
+	for _, s := range m.Stats() {
+		if got := [2]uint64{s.In, s.Out}; got != want[s.Name] {
+			t.Errorf("%s: in/out = %v, want %v", s.Name, got, want[s.Name])
+		}
+		if s.Name == "window" && s.Latency.Count != 5 {
+			t.Errorf("window: %d latencies recorded, want 5", s.Latency.Count)
+		}
+	}
+}
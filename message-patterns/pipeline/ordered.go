@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// Sequenced tags a value with its position in the original stream.
+type Sequenced[T any] struct {
+	Seq   int
+	Value T
+}
+
+// Sequence numbers the values of in from 0, in arrival order.
+func Sequence[T any](ctx context.Context, in <-chan T) <-chan Sequenced[T] {
+	out := make(chan Sequenced[T])
+
+	go func() {
+		defer close(out)
+		for seq := 0; ; seq++ {
+			v, ok := receive(ctx, in)
+			if !ok || !send(ctx, out, Sequenced[T]{Seq: seq, Value: v}) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// OrderedMerge fans in sequenced values and emits them in Seq order, starting
+// from 0. Values that arrive early wait in a reorder buffer of at most window
+// entries; when it is full, inputs are not read (so their senders block) until
+// the next value in sequence arrives, which is always accepted.
+//
+// Each input must deliver increasing sequence numbers, as the outputs of
+// FanOut over a Sequence do. If the inputs close with numbers missing, the
+// buffered values are flushed in order, skipping the gaps.
+func OrderedMerge[T any](ctx context.Context, window int, ins ...<-chan Sequenced[T]) <-chan Sequenced[T] {
+	if window < 1 {
+		window = 1
+	}
+	out := make(chan Sequenced[T])
+	buf := &reorderBuffer[T]{
+		window:  window,
+		pending: make(map[int]T),
+		open:    len(ins),
+	}
+	buf.cond = sync.NewCond(&buf.mu)
+
+	// Wake every waiter on cancellation, as Cond.Wait cannot select on ctx
+	stop := context.AfterFunc(ctx, func() {
+		buf.mu.Lock()
+		buf.cond.Broadcast()
+		buf.mu.Unlock()
+	})
+
+	for _, in := range ins {
+		go buf.fill(ctx, in)
+	}
+
+	go func() {
+		defer close(out)
+		defer stop()
+		for {
+			v, ok := buf.take(ctx)
+			if !ok || !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// reorderBuffer holds values that arrived ahead of their turn.
+type reorderBuffer[T any] struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	window  int
+	next    int       // Sequence number to emit next
+	pending map[int]T // Early values by sequence number
+	open    int       // Inputs not yet closed
+}
+
+// fill moves values from one input into the buffer, waiting for space.
+func (b *reorderBuffer[T]) fill(ctx context.Context, in <-chan Sequenced[T]) {
+	defer func() {
+		b.mu.Lock()
+		b.open--
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	}()
+
+	for {
+		v, ok := receive(ctx, in)
+		if !ok {
+			return
+		}
+
+		b.mu.Lock()
+		for v.Seq != b.next && len(b.pending) >= b.window && ctx.Err() == nil {
+			b.cond.Wait()
+		}
+		if ctx.Err() != nil {
+			b.mu.Unlock()
+			return
+		}
+		if v.Seq >= b.next { // Anything older was already skipped as a gap
+			b.pending[v.Seq] = v.Value
+			b.cond.Broadcast()
+		}
+		b.mu.Unlock()
+	}
+}
+
+// take waits for the next value in sequence. ok is false once everything has
+// been emitted or ctx is cancelled.
+func (b *reorderBuffer[T]) take(ctx context.Context) (Sequenced[T], bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ctx.Err() == nil {
+		if v, ok := b.pending[b.next]; ok {
+			return b.emit(v), true
+		}
+		if b.open == 0 {
+			if len(b.pending) == 0 {
+				return Sequenced[T]{}, false
+			}
+			// Inputs are done, jump over the gap to the lowest buffered value
+			b.next = b.lowest()
+			continue
+		}
+		b.cond.Wait()
+	}
+	return Sequenced[T]{}, false
+}
+
+func (b *reorderBuffer[T]) emit(v T) Sequenced[T] {
+	item := Sequenced[T]{Seq: b.next, Value: v}
+	delete(b.pending, b.next)
+	b.next++
+	b.cond.Broadcast() // Space for a blocked input
+	return item
+}
+
+func (b *reorderBuffer[T]) lowest() int {
+	first := true
+	lowest := 0
+	for seq := range b.pending {
+		if first || seq < lowest {
+			lowest, first = seq, false
+		}
+	}
+	return lowest
+}
+
+// OrderedFanOut runs fn on n workers like FanOut, but returns the results in
+// the order their inputs arrived. window bounds how many finished results may
+// wait for a slower predecessor before the workers are held back.
+func OrderedFanOut[In, Out any](ctx context.Context, in <-chan In, n, window int, fn func(ctx context.Context, worker int, v In) Out) <-chan Out {
+	// Only the workers are observed, so values count once on the way in and out
+	inner := unobserved(ctx)
+	workers := FanOut(ctx, Sequence(inner, in), n, func(ctx context.Context, worker int, v Sequenced[In]) Sequenced[Out] {
+		return Sequenced[Out]{Seq: v.Seq, Value: fn(ctx, worker, v.Value)}
+	})
+	return Map(inner, OrderedMerge(inner, window, workers...), func(_ context.Context, v Sequenced[Out]) Out {
+		return v.Value
+	})
+}
@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HashRing maps keys to nodes by consistent hashing, so adding or removing a
+// node only moves the keys next to its points on the ring.
+type HashRing struct {
+	replicas int
+	points   []uint64       // Sorted hashes of every virtual node
+	owners   map[uint64]int // Virtual node hash to node
+	nodes    map[int]bool
+}
+
+// NewHashRing returns an empty ring placing each node at replicas points.
+func NewHashRing(replicas int) *HashRing {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &HashRing{
+		replicas: replicas,
+		owners:   make(map[uint64]int),
+		nodes:    make(map[int]bool),
+	}
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Add places a node on the ring.
+func (r *HashRing) Add(node int) {
+	if r.nodes[node] {
+		return
+	}
+	r.nodes[node] = true
+	for i := 0; i < r.replicas; i++ {
+		point := hashKey(strconv.Itoa(node) + "#" + strconv.Itoa(i))
+		r.owners[point] = node
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Remove takes a node off the ring.
+func (r *HashRing) Remove(node int) {
+	if !r.nodes[node] {
+		return
+	}
+	delete(r.nodes, node)
+	points := r.points[:0]
+	for _, point := range r.points {
+		if r.owners[point] == node {
+			delete(r.owners, point)
+			continue
+		}
+		points = append(points, point)
+	}
+	r.points = points
+}
+
+// Get returns the node owning key, or -1 if the ring is empty.
+func (r *HashRing) Get(key string) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0 // Wrap around
+	}
+	return r.owners[r.points[i]]
+}
+
+// Partitioned is a fan-out where every value is routed by key to a fixed
+// worker, so values with the same key are processed one at a time, in order.
+type Partitioned[In, Out any] struct {
+	key    func(In) string
+	fn     func(ctx context.Context, worker int, v In) Out
+	out    chan Out
+	resize chan int
+	done   chan struct{}
+
+	mu      sync.Mutex // Guards ring for WorkerFor
+	ring    *HashRing
+	queues  map[int]chan In
+	pending sync.WaitGroup // Values dispatched but not yet delivered
+	workers sync.WaitGroup
+}
+
+// partitionQueueSize is how many values may wait for each worker.
+const partitionQueueSize = 16
+
+// Partition starts n workers and routes every value of in to one of them by
+// hashing key(v) onto a consistent hash ring.
+func Partition[In, Out any](ctx context.Context, in <-chan In, n int, key func(In) string, fn func(ctx context.Context, worker int, v In) Out) *Partitioned[In, Out] {
+	p := &Partitioned[In, Out]{
+		key:    key,
+		fn:     fn,
+		out:    make(chan Out),
+		resize: make(chan int),
+		done:   make(chan struct{}),
+		ring:   NewHashRing(100),
+		queues: make(map[int]chan In),
+	}
+	p.grow(ctx, max(n, 1))
+
+	go func() {
+		defer close(p.done)
+		p.dispatch(ctx, in)
+		for _, q := range p.queues {
+			close(q)
+		}
+		p.workers.Wait()
+		close(p.out)
+	}()
+
+	return p
+}
+
+// Out returns the results of every worker.
+func (p *Partitioned[In, Out]) Out() <-chan Out {
+	return p.out
+}
+
+// WorkerFor returns the worker that currently owns key.
+func (p *Partitioned[In, Out]) WorkerFor(key string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ring.Get(key)
+}
+
+// Resize changes the number of workers. Dispatching pauses until every value
+// already routed has been processed, so a key that moves to another worker
+// never overlaps with its previous one. It returns false if the pipeline has
+// already finished.
+func (p *Partitioned[In, Out]) Resize(n int) bool {
+	select {
+	case p.resize <- max(n, 1):
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+func (p *Partitioned[In, Out]) dispatch(ctx context.Context, in <-chan In) {
+	stage := stageOf(ctx)
+	for {
+		start := stage.clock()
+		stage.queued(len(in))
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-p.resize:
+			p.pending.Wait()
+			if n > len(p.queues) {
+				p.grow(ctx, n)
+			} else {
+				p.shrink(n)
+			}
+		case v, ok := <-in:
+			stage.receiveDone(start, ok)
+			if !ok {
+				return
+			}
+			p.mu.Lock()
+			worker := p.ring.Get(p.key(v))
+			p.mu.Unlock()
+
+			p.pending.Add(1)
+			if !send(unobserved(ctx), p.queues[worker], v) {
+				p.pending.Done()
+				return
+			}
+		}
+	}
+}
+
+// grow starts workers up to n, numbered from 1.
+func (p *Partitioned[In, Out]) grow(ctx context.Context, n int) {
+	for id := len(p.queues) + 1; id <= n; id++ {
+		queue := make(chan In, partitionQueueSize)
+		p.queues[id] = queue
+		p.mu.Lock()
+		p.ring.Add(id)
+		p.mu.Unlock()
+
+		p.workers.Add(1)
+		go func(id int) {
+			defer p.workers.Done()
+			// After cancellation keep draining, without processing, so the
+			// dispatcher's pending count still reaches zero
+			for v := range queue {
+				if ctx.Err() == nil {
+					start := time.Now()
+					result := p.fn(ctx, id, v)
+					observe(ctx, start)
+					send(ctx, p.out, result)
+				}
+				p.pending.Done()
+			}
+		}(id)
+	}
+}
+
+// shrink stops the highest-numbered workers down to n. Their queues are empty.
+func (p *Partitioned[In, Out]) shrink(n int) {
+	for id := len(p.queues); id > n; id-- {
+		p.mu.Lock()
+		p.ring.Remove(id)
+		p.mu.Unlock()
+		close(p.queues[id])
+		delete(p.queues, id)
+	}
+}
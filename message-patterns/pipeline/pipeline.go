@@ -0,0 +1,93 @@
+// Package pipeline provides generic, context-aware building blocks for the
+// fan-out/fan-in message patterns: a Source produces values, Map and FanOut
+// transform them, Merge joins streams back together and Sink consumes them.
+//
+// Every stage runs in its own goroutines, owns and closes its output channel,
+// and stops as soon as its context is cancelled. A context from
+// Metrics.Stage makes a stage report what it is doing.
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// send delivers v to out unless ctx is cancelled first.
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	stage := stageOf(ctx)
+	start := stage.clock()
+	select {
+	case <-ctx.Done():
+		stage.sendDone(start, false)
+		return false
+	case out <- v:
+		stage.sendDone(start, true)
+		return true
+	}
+}
+
+// receive reads the next value from in. ok is false when in is closed or ctx
+// is cancelled.
+func receive[T any](ctx context.Context, in <-chan T) (v T, ok bool) {
+	stage := stageOf(ctx)
+	start := stage.clock()
+	stage.queued(len(in))
+	select {
+	case <-ctx.Done():
+		stage.receiveDone(start, false)
+		return v, false
+	case v, ok = <-in:
+		stage.receiveDone(start, ok)
+		return v, ok
+	}
+}
+
+// trySend is send without waiting: it returns false at once if out cannot
+// take v.
+func trySend[T any](ctx context.Context, out chan<- T, v T) bool {
+	stage := stageOf(ctx)
+	start := stage.clock()
+	select {
+	case out <- v:
+		stage.sendDone(start, true)
+		return true
+	default:
+		stage.sendDone(start, false)
+		return false
+	}
+}
+
+// tryReceive is receive without waiting: ready is false if in has no value
+// yet, and ok false with ready true means in is closed.
+func tryReceive[T any](ctx context.Context, in <-chan T) (v T, ok, ready bool) {
+	stage := stageOf(ctx)
+	start := stage.clock()
+	stage.queued(len(in))
+	select {
+	case v, ok = <-in:
+		stage.receiveDone(start, ok)
+		return v, ok, true
+	default:
+		stage.receiveDone(start, false)
+		return v, false, false
+	}
+}
+
+// receiveUntil is receive that also gives up when timeout fires, reporting
+// that with fired. A nil timeout never fires.
+func receiveUntil[T any](ctx context.Context, in <-chan T, timeout <-chan time.Time) (v T, ok, fired bool) {
+	stage := stageOf(ctx)
+	start := stage.clock()
+	stage.queued(len(in))
+	select {
+	case <-ctx.Done():
+		stage.receiveDone(start, false)
+		return v, false, false
+	case <-timeout:
+		stage.receiveDone(start, false)
+		return v, false, true
+	case v, ok = <-in:
+		stage.receiveDone(start, ok)
+		return v, ok, false
+	}
+}
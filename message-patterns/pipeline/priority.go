@@ -0,0 +1,224 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// MergeMode decides which input a Merger takes from when several have a
+// value ready.
+type MergeMode int
+
+const (
+	// RoundRobin takes from ready inputs in turn.
+	RoundRobin MergeMode = iota
+	// StrictPriority always drains the earliest ready input first; later
+	// inputs only get through when every earlier one has nothing ready.
+	StrictPriority
+	// WeightedFair shares the output between ready inputs in proportion to
+	// their weights, interleaving them smoothly.
+	WeightedFair
+)
+
+func (m MergeMode) String() string {
+	switch m {
+	case RoundRobin:
+		return "round-robin"
+	case StrictPriority:
+		return "priority"
+	case WeightedFair:
+		return "weighted"
+	}
+	return "unknown"
+}
+
+// MergeInput is one input of a Merger.
+type MergeInput[T any] struct {
+	In     <-chan T
+	Weight int // Share under WeightedFair, 1 when zero
+}
+
+// MergeStats counts the traffic of one Merger input.
+type MergeStats struct {
+	Emitted uint64
+	Rate    float64 // Emitted values per second since the merge started
+}
+
+// Merger fans in several inputs by a MergeMode. Unlike Merge, a single
+// goroutine makes every choice, so given which inputs have a value ready the
+// order of the output is deterministic.
+type Merger[T any] struct {
+	out     chan T
+	started time.Time
+	emitted []atomic.Uint64
+}
+
+// mergeInput tracks the state of one input inside the merge loop.
+type mergeInput[T any] struct {
+	in      <-chan T
+	weight  int
+	current int // Smooth weighted round-robin credit
+	head    T
+	ready   bool
+	closed  bool
+}
+
+// MergeBy starts merging inputs by mode. The output is closed when every
+// input is closed and drained, or ctx is cancelled.
+func MergeBy[T any](ctx context.Context, mode MergeMode, inputs ...MergeInput[T]) *Merger[T] {
+	m := &Merger[T]{
+		out:     make(chan T),
+		started: time.Now(),
+		emitted: make([]atomic.Uint64, len(inputs)),
+	}
+
+	state := make([]*mergeInput[T], len(inputs))
+	for i, input := range inputs {
+		weight := input.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		state[i] = &mergeInput[T]{in: input.In, weight: weight}
+	}
+
+	go func() {
+		defer close(m.out)
+		last := -1 // Input chosen last, for RoundRobin
+		for {
+			if !poll(ctx, state) && !wait(ctx, state) {
+				return
+			}
+
+			var i int
+			switch mode {
+			case StrictPriority:
+				i = pickPriority(state)
+			case WeightedFair:
+				i = pickWeighted(state)
+			default:
+				i = pickRoundRobin(state, last)
+			}
+			last = i
+
+			v := state[i].head
+			var zero T
+			state[i].head, state[i].ready = zero, false
+			if !send(ctx, m.out, v) {
+				return
+			}
+			m.emitted[i].Add(1)
+		}
+	}()
+
+	return m
+}
+
+// poll fills every empty head that can be filled without blocking and
+// reports whether any input has a value ready.
+func poll[T any](ctx context.Context, state []*mergeInput[T]) bool {
+	ready := false
+	for _, s := range state {
+		if !s.ready && !s.closed {
+			if v, ok, got := tryReceive(ctx, s.in); ok {
+				s.head, s.ready = v, true
+			} else if got {
+				s.closed = true
+			}
+		}
+		ready = ready || s.ready
+	}
+	return ready
+}
+
+// wait blocks until one open input delivers a value. It returns false when
+// every input is closed or ctx is cancelled.
+func wait[T any](ctx context.Context, state []*mergeInput[T]) bool {
+	for {
+		cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}}
+		var index []int
+		for i, s := range state {
+			if !s.closed {
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.in)})
+				index = append(index, i)
+			}
+		}
+		if len(index) == 0 {
+			return false
+		}
+
+		stage := stageOf(ctx)
+		start := stage.clock()
+		chosen, v, ok := reflect.Select(cases)
+		stage.receiveDone(start, chosen > 0 && ok)
+		if chosen == 0 {
+			return false
+		}
+		s := state[index[chosen-1]]
+		if !ok {
+			s.closed = true
+			continue
+		}
+		s.head, s.ready = v.Interface().(T), true
+		// Give the other inputs a chance to be ready too, so the policy has a choice
+		poll(ctx, state)
+		return true
+	}
+}
+
+func pickPriority[T any](state []*mergeInput[T]) int {
+	for i, s := range state {
+		if s.ready {
+			return i
+		}
+	}
+	return -1
+}
+
+func pickRoundRobin[T any](state []*mergeInput[T], last int) int {
+	for n := 1; n <= len(state); n++ {
+		i := (last + n) % len(state)
+		if state[i].ready {
+			return i
+		}
+	}
+	return -1
+}
+
+// pickWeighted is smooth weighted round-robin over the ready inputs: each
+// gains its weight in credit, the richest wins and pays the total back.
+func pickWeighted[T any](state []*mergeInput[T]) int {
+	best, total := -1, 0
+	for i, s := range state {
+		if !s.ready {
+			continue
+		}
+		s.current += s.weight
+		total += s.weight
+		if best < 0 || s.current > state[best].current {
+			best = i
+		}
+	}
+	state[best].current -= total
+	return best
+}
+
+// Out returns the merged stream.
+func (m *Merger[T]) Out() <-chan T {
+	return m.out
+}
+
+// Stats returns the traffic of every input, in input order.
+func (m *Merger[T]) Stats() []MergeStats {
+	elapsed := time.Since(m.started).Seconds()
+	stats := make([]MergeStats, len(m.emitted))
+	for i := range m.emitted {
+		n := m.emitted[i].Load()
+		stats[i] = MergeStats{Emitted: n}
+		if elapsed > 0 {
+			stats[i].Rate = float64(n) / elapsed
+		}
+	}
+	return stats
+}
@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// filled returns a closed channel already holding n values named prefix0,
+// prefix1, ..., so every input of a merge is ready from the start and the
+// order depends only on the mode.
+func filled(prefix string, n int) <-chan string {
+	ch := make(chan string, n)
+	for i := 0; i < n; i++ {
+		ch <- fmt.Sprintf("%s%d", prefix, i)
+	}
+	close(ch)
+	return ch
+}
+
+func TestMergeByOrder(t *testing.T) {
+	cases := []struct {
+		mode   MergeMode
+		inputs func() []MergeInput[string]
+		want   []string
+	}{
+		{
+			mode: StrictPriority,
+			inputs: func() []MergeInput[string] {
+				return []MergeInput[string]{{In: filled("a", 2)}, {In: filled("b", 2)}, {In: filled("c", 1)}}
+			},
+			want: []string{"a0", "a1", "b0", "b1", "c0"},
+		},
+		{
+			mode: RoundRobin,
+			inputs: func() []MergeInput[string] {
+				return []MergeInput[string]{{In: filled("a", 3)}, {In: filled("b", 1)}, {In: filled("c", 2)}}
+			},
+			want: []string{"a0", "b0", "c0", "a1", "c1", "a2"},
+		},
+		{
+			mode: WeightedFair,
+			inputs: func() []MergeInput[string] {
+				return []MergeInput[string]{{In: filled("a", 6), Weight: 3}, {In: filled("b", 4)}}
+			},
+			// Smooth weighted round-robin interleaves 3:1 as a a b a, then
+			// b drains alone once a is empty
+			want: []string{"a0", "a1", "b0", "a2", "a3", "a4", "b1", "a5", "b2", "b3"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.mode.String(), func(t *testing.T) {
+			ctx := context.Background()
+			m := MergeBy(ctx, c.mode, c.inputs()...)
+			got, err := Collect(ctx, m.Out())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package pipeline
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueueOptions tunes a DurableQueue.
+type QueueOptions struct {
+	// SegmentSize is the size at which the log rolls over to a new segment
+	// file. Defaults to 1 MiB.
+	SegmentSize int64
+	// VisibilityTimeout is how long a delivered job may stay unacknowledged
+	// before it is delivered again. Defaults to 30 seconds.
+	VisibilityTimeout time.Duration
+	// Sync calls fsync after every write, trading throughput for durability
+	// against power loss rather than only process crashes.
+	Sync bool
+}
+
+// ErrQueueClosed is returned by operations on a closed DurableQueue.
+var ErrQueueClosed = errors.New("pipeline: queue is closed")
+
+// Delivery is a job handed out by a DurableQueue. It must be acknowledged
+// once processed, or it will be delivered again.
+type Delivery[T any] struct {
+	ID      uint64
+	Value   T
+	Attempt int // 1 for the first delivery since the queue was opened
+	queue   *DurableQueue[T]
+}
+
+// Ack marks the job as done so it is never delivered again.
+func (d Delivery[T]) Ack() error {
+	return d.queue.Ack(d.ID)
+}
+
+// DurableQueue is a job queue persisted in a segmented write-ahead log, with
+// at-least-once delivery: jobs not acknowledged are delivered again after the
+// visibility timeout or when the queue is reopened after a crash. Segments
+// whose jobs are all acknowledged are deleted.
+type DurableQueue[T any] struct {
+	dir  string
+	opts QueueOptions
+
+	mu       sync.Mutex
+	closed   bool
+	nextID   uint64
+	segments []*segment // Oldest first; the last one is appended to
+	active   *os.File
+	writer   *bufio.Writer
+	size     int64 // Bytes in the active segment
+	jobs     map[uint64]*queuedJob
+	ready    idHeap        // Jobs waiting for delivery, oldest first
+	wake     chan struct{} // Signalled when ready gains a job
+	done     chan struct{} // Closed by Close
+}
+
+// segment is one log file and how many of its jobs are not yet acknowledged.
+type segment struct {
+	first   uint64 // ID of the first job that may be in it
+	path    string
+	unacked int
+}
+
+type queuedJob struct {
+	payload   []byte
+	segment   *segment
+	attempts  int
+	inflight  bool
+	visibleAt time.Time // When an in-flight job becomes ready again
+}
+
+const (
+	recordEnqueue byte = 1
+	recordAck     byte = 2
+
+	recordHeaderLen = 8 // Body length and CRC-32, both uint32
+	recordBodyMin   = 9 // Type and job ID
+
+	// maxRecordSize bounds a record body, so a corrupt length read from disk
+	// cannot make recovery allocate gigabytes.
+	maxRecordSize = 16 << 20
+)
+
+// OpenQueue opens or creates the queue stored in dir. Jobs left
+// unacknowledged by a previous run are ready for delivery again.
+func OpenQueue[T any](dir string, opts QueueOptions) (*DurableQueue[T], error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 1 << 20
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = 30 * time.Second
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	q := &DurableQueue[T]{
+		dir:    dir,
+		opts:   opts,
+		nextID: 1,
+		jobs:   make(map[uint64]*queuedJob),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if err := q.recover(); err != nil {
+		return nil, err
+	}
+	for id := range q.jobs {
+		heap.Push(&q.ready, id)
+	}
+
+	go q.watchVisibility()
+	return q, nil
+}
+
+// recover replays every segment into memory and opens the last for appending.
+func (q *DurableQueue[T]) recover() error {
+	names, err := filepath.Glob(filepath.Join(q.dir, "*.wal"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names) // Names are zero-padded first IDs
+
+	for i, name := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".wal"), 16, 64)
+		if err != nil {
+			return fmt.Errorf("pipeline: unexpected file in queue directory: %s", name)
+		}
+		seg := &segment{first: first, path: name}
+		q.segments = append(q.segments, seg)
+		if first > q.nextID {
+			q.nextID = first
+		}
+
+		last := i == len(names)-1
+		good, err := q.replay(seg, last)
+		if err != nil {
+			return err
+		}
+		if last {
+			// Drop a record torn by a crash mid-write
+			if err := os.Truncate(name, good); err != nil {
+				return err
+			}
+			q.size = good
+		}
+	}
+
+	if len(q.segments) == 0 {
+		return q.roll()
+	}
+	f, err := os.OpenFile(q.segments[len(q.segments)-1].path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	q.active, q.writer = f, bufio.NewWriter(f)
+	return q.compact()
+}
+
+// replay applies the records of one segment and returns the offset after the
+// last valid record. Only the last segment may end in a damaged record.
+func (q *DurableQueue[T]) replay(seg *segment, last bool) (int64, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		kind, id, payload, n, err := readRecord(r)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			if last {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("pipeline: corrupt queue segment %s at offset %d: %w", seg.path, offset, err)
+		}
+		offset += n
+
+		switch kind {
+		case recordEnqueue:
+			q.jobs[id] = &queuedJob{payload: payload, segment: seg}
+			seg.unacked++
+			if id >= q.nextID {
+				q.nextID = id + 1
+			}
+		case recordAck:
+			if job, ok := q.jobs[id]; ok {
+				job.segment.unacked--
+				delete(q.jobs, id)
+			}
+		}
+	}
+}
+
+func readRecord(r io.Reader) (kind byte, id uint64, payload []byte, n int64, err error) {
+	header := make([]byte, recordHeaderLen)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated record header")
+		}
+		return
+	}
+	length := binary.BigEndian.Uint32(header[0:])
+	if length < recordBodyMin || length > maxRecordSize {
+		err = fmt.Errorf("invalid record length %d", length)
+		return
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = errors.New("truncated record")
+		return
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+		err = errors.New("checksum mismatch")
+		return
+	}
+	return body[0], binary.BigEndian.Uint64(body[1:]), body[recordBodyMin:], int64(recordHeaderLen + length), nil
+}
+
+// write appends a record to the active segment. Callers must hold q.mu.
+func (q *DurableQueue[T]) write(kind byte, id uint64, payload []byte) error {
+	body := make([]byte, recordBodyMin+len(payload))
+	body[0] = kind
+	binary.BigEndian.PutUint64(body[1:], id)
+	copy(body[recordBodyMin:], payload)
+
+	header := make([]byte, recordHeaderLen)
+	binary.BigEndian.PutUint32(header[0:], uint32(len(body)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(body))
+
+	if _, err := q.writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := q.writer.Write(body); err != nil {
+		return err
+	}
+	if err := q.writer.Flush(); err != nil {
+		return err
+	}
+	if q.opts.Sync {
+		if err := q.active.Sync(); err != nil {
+			return err
+		}
+	}
+	q.size += int64(len(header) + len(body))
+	return nil
+}
+
+// roll starts a new segment for the jobs from nextID on. Callers must hold q.mu.
+func (q *DurableQueue[T]) roll() error {
+	if q.active != nil {
+		if err := q.active.Close(); err != nil {
+			return err
+		}
+	}
+	seg := &segment{first: q.nextID, path: filepath.Join(q.dir, fmt.Sprintf("%016x.wal", q.nextID))}
+	f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	q.segments = append(q.segments, seg)
+	q.active, q.writer, q.size = f, bufio.NewWriter(f), 0
+	return nil
+}
+
+// compact deletes the oldest segments while all their jobs are acknowledged.
+// Only a prefix is deleted, so an ack record is never lost while the job it
+// acknowledges is still on disk. A segment that cannot be removed is kept and
+// tried again by the next compact. Callers must hold q.mu.
+func (q *DurableQueue[T]) compact() error {
+	for len(q.segments) > 1 && q.segments[0].unacked == 0 {
+		if err := os.Remove(q.segments[0].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("pipeline: removing acknowledged queue segment: %w", err)
+		}
+		q.segments = q.segments[1:]
+	}
+	return nil
+}
+
+// Enqueue appends a job to the log and makes it ready for delivery.
+func (q *DurableQueue[T]) Enqueue(v T) (uint64, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) > maxRecordSize-recordBodyMin {
+		return 0, fmt.Errorf("pipeline: job of %d bytes is larger than the %d-byte queue record limit", len(payload), maxRecordSize-recordBodyMin)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+
+	if q.size >= q.opts.SegmentSize {
+		if err := q.roll(); err != nil {
+			return 0, err
+		}
+	}
+	id := q.nextID
+	if err := q.write(recordEnqueue, id, payload); err != nil {
+		return 0, err
+	}
+	q.nextID++
+
+	seg := q.segments[len(q.segments)-1]
+	seg.unacked++
+	q.jobs[id] = &queuedJob{payload: payload, segment: seg}
+	heap.Push(&q.ready, id)
+	q.signal()
+	return id, nil
+}
+
+// Ack marks a job as done. Acknowledging an unknown or already acknowledged
+// job is not an error, as redelivered jobs may be acknowledged twice. An error
+// deleting a segment left fully acknowledged is returned even though the ack
+// itself was recorded.
+func (q *DurableQueue[T]) Ack(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil
+	}
+	if err := q.write(recordAck, id, nil); err != nil {
+		return err
+	}
+	delete(q.jobs, id)
+	job.segment.unacked--
+	return q.compact()
+}
+
+// Len returns the number of jobs not yet acknowledged.
+func (q *DurableQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs)
+}
+
+// Source delivers ready jobs, oldest first, until ctx is cancelled or the
+// queue is closed. Jobs that fail to decode are skipped and acknowledged.
+func (q *DurableQueue[T]) Source(ctx context.Context) <-chan Delivery[T] {
+	out := make(chan Delivery[T])
+
+	go func() {
+		defer close(out)
+		for {
+			d, ok := q.next(ctx)
+			if !ok {
+				return
+			}
+			if !send(ctx, out, d) {
+				// Not handed out after all, make it ready again
+				q.release(d.ID)
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// next waits for a ready job and marks it in flight.
+func (q *DurableQueue[T]) next(ctx context.Context) (Delivery[T], bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return Delivery[T]{}, false
+		}
+		for q.ready.Len() > 0 {
+			id := heap.Pop(&q.ready).(uint64)
+			job, ok := q.jobs[id]
+			if !ok || job.inflight {
+				continue // Acknowledged or already handed out again
+			}
+
+			var v T
+			if err := json.Unmarshal(job.payload, &v); err != nil {
+				q.mu.Unlock()
+				q.Ack(id)
+				q.mu.Lock()
+				continue
+			}
+			job.inflight = true
+			job.attempts++
+			job.visibleAt = time.Now().Add(q.opts.VisibilityTimeout)
+			q.mu.Unlock()
+			return Delivery[T]{ID: id, Value: v, Attempt: job.attempts, queue: q}, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Delivery[T]{}, false
+		case <-q.done:
+			return Delivery[T]{}, false
+		case <-q.wake:
+		}
+	}
+}
+
+// release makes an in-flight job ready again.
+func (q *DurableQueue[T]) release(id uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[id]; ok && job.inflight {
+		job.inflight = false
+		heap.Push(&q.ready, id)
+		q.signal()
+	}
+}
+
+// signal wakes a waiting Source. Callers must hold q.mu.
+func (q *DurableQueue[T]) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// watchVisibility redelivers jobs whose visibility timeout has expired.
+func (q *DurableQueue[T]) watchVisibility() {
+	ticker := time.NewTicker(q.opts.VisibilityTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case now := <-ticker.C:
+			q.mu.Lock()
+			for id, job := range q.jobs {
+				if job.inflight && now.After(job.visibleAt) {
+					job.inflight = false
+					heap.Push(&q.ready, id)
+					q.signal()
+				}
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// Close stops delivery and closes the log. Unacknowledged jobs stay on disk
+// for the next OpenQueue.
+func (q *DurableQueue[T]) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.done)
+	return q.active.Close()
+}
+
+// idHeap is a min-heap of job IDs.
+type idHeap []uint64
+
+func (h idHeap) Len() int           { return len(h) }
+func (h idHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h idHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *idHeap) Push(x any)        { *h = append(*h, x.(uint64)) }
+func (h *idHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
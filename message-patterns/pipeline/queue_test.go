@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueueRecoversFromOversizedRecordLength(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue[string](dir, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue("kept"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn tail whose length field claims a 4 GiB record
+	names, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	info, _ := os.Stat(names[0])
+	f, err := os.OpenFile(names[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, recordHeaderLen)
+	binary.BigEndian.PutUint32(header, 0xffffffff)
+	f.Write(append(header, "garbage"...))
+	f.Close()
+
+	q, err = OpenQueue[string](dir, QueueOptions{})
+	if err != nil {
+		t.Fatalf("OpenQueue() = %v, want the corrupt tail dropped", err)
+	}
+	defer q.Close()
+	if after, _ := os.Stat(names[0]); after.Size() != info.Size() {
+		t.Fatalf("segment is %d bytes, want the tail truncated back to %d", after.Size(), info.Size())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := <-q.Source(ctx)
+	if d.Value != "kept" {
+		t.Fatalf("delivered %q, want the job written before the tail", d.Value)
+	}
+}
+
+func TestQueueRejectsOversizedJob(t *testing.T) {
+	q, err := OpenQueue[[]byte](t.TempDir(), QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if _, err := q.Enqueue(make([]byte, maxRecordSize)); err == nil {
+		t.Fatal("Enqueue of a job over the record limit succeeded")
+	}
+	if q.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0", q.Len())
+	}
+}
@@ -0,0 +1,406 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// CoordinatorConfig tunes a Coordinator.
+type CoordinatorConfig struct {
+	HeartbeatInterval time.Duration // Defaults to DefaultHeartbeatInterval
+	HeartbeatTimeout  time.Duration // Defaults to DefaultHeartbeatTimeout
+
+	// OnJoin, if set, is called when a worker connects.
+	OnJoin func(worker string)
+	// OnLeave, if set, is called when a worker disconnects or is declared
+	// dead, with the number of its jobs handed back for reassignment.
+	OnLeave func(worker string, reassigned int, err error)
+}
+
+// WorkerStats describes one connected worker.
+type WorkerStats struct {
+	Name      string
+	Addr      string
+	Credits   int
+	InFlight  int
+	Completed int
+}
+
+// Coordinator serves jobs to remote workers and collects their results.
+type Coordinator[In, Out any] struct {
+	cfg  CoordinatorConfig
+	out  chan pipeline.Outcome[Out]
+	jobs chan task
+	wg   sync.WaitGroup
+
+	mu          sync.Mutex
+	requeued    []task
+	requeue     chan struct{} // Signalled when requeued gains a job
+	nextID      uint64
+	outstanding int // Jobs taken from the input without a result yet
+	inputDone   bool
+	finished    chan struct{} // Closed once every job has a result
+	sessions    map[*session]struct{}
+}
+
+type task struct {
+	id      uint64
+	payload json.RawMessage
+}
+
+type session struct {
+	name      string
+	addr      string
+	credits   int
+	inflight  map[uint64]task
+	completed int
+}
+
+// Serve distributes the values of in to the workers that connect to ln, and
+// emits one outcome per value as results come back, in completion order. Jobs
+// held by a worker that disconnects or stops sending heartbeats are handed to
+// another worker, so a job may run more than once. The output is closed, and
+// ln with it, once every value of in has a result or ctx is cancelled.
+func Serve[In, Out any](ctx context.Context, ln net.Listener, in <-chan In, cfg CoordinatorConfig) *Coordinator[In, Out] {
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.HeartbeatTimeout <= 0 {
+		cfg.HeartbeatTimeout = DefaultHeartbeatTimeout
+	}
+
+	c := &Coordinator[In, Out]{
+		cfg:      cfg,
+		out:      make(chan pipeline.Outcome[Out]),
+		jobs:     make(chan task),
+		requeue:  make(chan struct{}, 1),
+		finished: make(chan struct{}),
+		sessions: make(map[*session]struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c.wg.Add(2)
+	go c.feed(ctx, in)
+	go c.accept(ctx, ln)
+
+	go func() {
+		select {
+		case <-c.finished:
+		case <-ctx.Done():
+		}
+		cancel()
+		ln.Close()
+		c.wg.Wait()
+		close(c.out)
+	}()
+
+	return c
+}
+
+// Out returns the stream of outcomes.
+func (c *Coordinator[In, Out]) Out() <-chan pipeline.Outcome[Out] {
+	return c.out
+}
+
+// Workers returns the connected workers, sorted by name.
+func (c *Coordinator[In, Out]) Workers() []WorkerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make([]WorkerStats, 0, len(c.sessions))
+	for s := range c.sessions {
+		stats = append(stats, WorkerStats{
+			Name:      s.name,
+			Addr:      s.addr,
+			Credits:   s.credits,
+			InFlight:  len(s.inflight),
+			Completed: s.completed,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// feed hands jobs to whichever session is ready for one, reassigned jobs first.
+func (c *Coordinator[In, Out]) feed(ctx context.Context, in <-chan In) {
+	defer c.wg.Done()
+	for {
+		t, ok := c.next(ctx, &in)
+		if !ok {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case c.jobs <- t:
+		}
+	}
+}
+
+// next returns the next job to hand out. It sets *in to nil once the input is
+// drained, then keeps waiting for reassigned jobs until all are done.
+func (c *Coordinator[In, Out]) next(ctx context.Context, in *<-chan In) (task, bool) {
+	for {
+		c.mu.Lock()
+		if len(c.requeued) > 0 {
+			t := c.requeued[0]
+			c.requeued = c.requeued[1:]
+			c.mu.Unlock()
+			return t, true
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return task{}, false
+		case <-c.finished:
+			return task{}, false
+		case <-c.requeue:
+		case v, ok := <-*in:
+			if !ok {
+				*in = nil
+				c.mu.Lock()
+				c.inputDone = true
+				c.checkFinished()
+				c.mu.Unlock()
+				continue
+			}
+
+			payload, err := json.Marshal(v)
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					return task{}, false
+				case c.out <- pipeline.Outcome[Out]{Err: fmt.Errorf("remote: encoding job: %w", err)}:
+				}
+				continue
+			}
+			c.mu.Lock()
+			c.nextID++
+			c.outstanding++
+			t := task{id: c.nextID, payload: payload}
+			c.mu.Unlock()
+			return t, true
+		}
+	}
+}
+
+// checkFinished closes finished once the input is drained and every job has
+// a result. Callers must hold c.mu.
+func (c *Coordinator[In, Out]) checkFinished() {
+	if c.inputDone && c.outstanding == 0 {
+		select {
+		case <-c.finished:
+		default:
+			close(c.finished)
+		}
+	}
+}
+
+// reassign hands a dead session's jobs back for another worker.
+func (c *Coordinator[In, Out]) reassign(tasks map[uint64]task) {
+	if len(tasks) == 0 {
+		return
+	}
+	ids := make([]uint64, 0, len(tasks))
+	for id := range tasks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	c.mu.Lock()
+	for _, id := range ids {
+		c.requeued = append(c.requeued, tasks[id])
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.requeue <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Coordinator[In, Out]) accept(ctx context.Context, ln net.Listener) {
+	defer c.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return // Closed once the coordinator is done
+		}
+		c.wg.Add(1)
+		go c.serve(ctx, conn)
+	}
+}
+
+// serve runs one worker connection until it dies or the coordinator is done.
+func (c *Coordinator[In, Out]) serve(ctx context.Context, conn net.Conn) {
+	defer c.wg.Done()
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(c.cfg.HeartbeatTimeout))
+	kind, body, err := readFrame(conn)
+	if err != nil || kind != frameHello {
+		return
+	}
+	var h hello
+	if err := json.Unmarshal(body, &h); err != nil || h.Credits < 1 {
+		return
+	}
+	if h.Name == "" {
+		h.Name = conn.RemoteAddr().String()
+	}
+
+	s := &session{name: h.Name, addr: conn.RemoteAddr().String(), credits: h.Credits, inflight: make(map[uint64]task)}
+	c.mu.Lock()
+	c.sessions[s] = struct{}{}
+	c.mu.Unlock()
+	if c.cfg.OnJoin != nil {
+		c.cfg.OnJoin(s.name)
+	}
+
+	err = c.run(ctx, conn, s)
+
+	c.mu.Lock()
+	delete(c.sessions, s)
+	inflight := s.inflight
+	s.inflight = nil
+	c.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return // Shutting down, there is no one left to reassign to
+	}
+	c.reassign(inflight)
+	if c.cfg.OnLeave != nil {
+		c.cfg.OnLeave(s.name, len(inflight), err)
+	}
+}
+
+// run exchanges jobs, results and heartbeats with one worker. It returns the
+// error that ended the connection.
+func (c *Coordinator[In, Out]) run(ctx context.Context, conn net.Conn, s *session) error {
+	results := make(chan resultFrame)
+	dead := make(chan error, 2) // One from the reader, one from the heartbeats
+	stop := make(chan struct{})
+	defer close(stop)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			conn.SetReadDeadline(time.Now().Add(c.cfg.HeartbeatTimeout))
+			kind, body, err := readFrame(conn)
+			if err != nil {
+				dead <- err
+				return
+			}
+			if kind != frameResult {
+				continue // Heartbeats only refresh the deadline
+			}
+			var r resultFrame
+			if err := json.Unmarshal(body, &r); err != nil {
+				dead <- err
+				return
+			}
+			select {
+			case results <- r:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// Jobs and heartbeats are written from different goroutines, so that
+	// heartbeats keep flowing while the loop below waits on a slow consumer of
+	// the results.
+	var writeMu sync.Mutex
+	write := func(kind byte, body any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(c.cfg.HeartbeatTimeout))
+		return writeFrame(conn, kind, body)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		heartbeat := time.NewTicker(c.cfg.HeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-heartbeat.C:
+				if err := write(frameHeartbeat, nil); err != nil {
+					dead <- err
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		// Only take a job while the worker has credit for it
+		var jobs <-chan task
+		c.mu.Lock()
+		if len(s.inflight) < s.credits {
+			jobs = c.jobs
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-dead:
+			if errors.Is(err, net.ErrClosed) {
+				err = nil
+			}
+			return err
+
+		case t := <-jobs:
+			c.mu.Lock()
+			s.inflight[t.id] = t
+			c.mu.Unlock()
+			if err := write(frameJob, jobFrame{ID: t.id, Payload: t.payload}); err != nil {
+				return err
+			}
+
+		case r := <-results:
+			c.mu.Lock()
+			_, ok := s.inflight[r.ID]
+			delete(s.inflight, r.ID)
+			if ok {
+				s.completed++
+			}
+			c.mu.Unlock()
+			if !ok {
+				continue // Not ours, or already reassigned
+			}
+
+			var outcome pipeline.Outcome[Out]
+			if r.Error != "" {
+				outcome.Err = &RemoteError{Worker: s.name, Message: r.Error}
+			} else if err := json.Unmarshal(r.Payload, &outcome.Value); err != nil {
+				outcome.Err = fmt.Errorf("remote: decoding result from %s: %w", s.name, err)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case c.out <- outcome:
+			}
+
+			c.mu.Lock()
+			c.outstanding--
+			c.checkFinished()
+			c.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package remote spreads a pipeline's fan-out across processes and machines.
+// A Coordinator serves jobs from a channel to workers connected over TCP, and
+// Work runs the worker side of the connection.
+//
+// Every frame on the wire is a 4-byte big-endian length, a 1-byte type and a
+// JSON body. A worker opens with a hello frame announcing its credit window,
+// the number of jobs it is willing to hold at once; the coordinator never has
+// more jobs outstanding on a connection than that, and each result returns
+// one credit. Both sides send heartbeats, and a connection silent for longer
+// than the heartbeat timeout is treated as dead.
+package remote
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	frameHello     byte = 1 // Worker to coordinator
+	frameJob       byte = 2 // Coordinator to worker
+	frameResult    byte = 3 // Worker to coordinator
+	frameHeartbeat byte = 4 // Both ways
+
+	maxFrameSize = 16 << 20
+)
+
+// Defaults shared by the coordinator and workers.
+const (
+	DefaultHeartbeatInterval = time.Second
+	DefaultHeartbeatTimeout  = 5 * time.Second
+)
+
+type hello struct {
+	Name    string `json:"name"`
+	Credits int    `json:"credits"`
+}
+
+type jobFrame struct {
+	ID      uint64          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type resultFrame struct {
+	ID      uint64          `json:"id"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// writeFrame encodes body as JSON and writes it as one frame.
+func writeFrame(w io.Writer, kind byte, body any) error {
+	payload := []byte("{}")
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[0:], uint32(1+len(payload)))
+	frame[4] = kind
+	copy(frame[5:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads the next frame and returns its type and JSON body.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < 1 || length > maxFrameSize {
+		return 0, nil, fmt.Errorf("remote: invalid frame length %d", length)
+	}
+	body := make([]byte, length-1)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[4], body, nil
+}
+
+// RemoteError is a job failure reported by a worker.
+type RemoteError struct {
+	Worker  string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote: worker %s: %s", e.Worker, e.Message)
+}
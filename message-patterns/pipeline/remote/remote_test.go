@@ -0,0 +1,278 @@
+//go:build unix
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// The tests run their workers as separate processes: the test binary is
+// started again with REMOTE_TEST_WORKER set, and TestMain turns it into a
+// worker instead of running the tests.
+
+const (
+	testHeartbeatInterval = 50 * time.Millisecond
+	testHeartbeatTimeout  = 500 * time.Millisecond
+)
+
+// testResult is what a test worker returns for each job.
+type testResult struct {
+	Worker  string
+	Square  int
+	Running int // Jobs the worker was running, this one included, when it started
+}
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("REMOTE_TEST_WORKER"); mode != "" {
+		os.Exit(workerMain(mode))
+	}
+	os.Exit(m.Run())
+}
+
+// workerMain runs a worker process. In "square" mode each job takes a little
+// while; in "hang" mode jobs never finish, so the worker holds on to them.
+func workerMain(mode string) int {
+	name := os.Getenv("REMOTE_TEST_NAME")
+	credits, _ := strconv.Atoi(os.Getenv("REMOTE_TEST_CREDITS"))
+
+	var running atomic.Int32
+	cfg := WorkerConfig{
+		Name:              name,
+		Credits:           credits,
+		HeartbeatInterval: testHeartbeatInterval,
+		HeartbeatTimeout:  testHeartbeatTimeout,
+	}
+	err := Work(context.Background(), os.Getenv("REMOTE_TEST_ADDR"), cfg, func(ctx context.Context, v int) (testResult, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		if mode == "hang" {
+			<-ctx.Done()
+			return testResult{}, ctx.Err()
+		}
+		time.Sleep(30 * time.Millisecond)
+		return testResult{Worker: name, Square: v * v, Running: int(n)}, nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "worker:", err)
+		return 1
+	}
+	return 0
+}
+
+// startWorker runs a worker process connected to addr. It is killed when the
+// test ends, if it has not exited by then.
+func startWorker(t *testing.T, addr, name, mode string, credits int) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(),
+		"REMOTE_TEST_WORKER="+mode,
+		"REMOTE_TEST_ADDR="+addr,
+		"REMOTE_TEST_NAME="+name,
+		"REMOTE_TEST_CREDITS="+strconv.Itoa(credits),
+	)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+// leave records a call to CoordinatorConfig.OnLeave.
+type leave struct {
+	worker     string
+	reassigned int
+	err        error
+}
+
+// serve starts a coordinator on a loopback port for the values 1 to n.
+func serve(t *testing.T, ctx context.Context, n int, left chan<- leave) (*Coordinator[int, testResult], string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]int, n)
+	for i := range values {
+		values[i] = i + 1
+	}
+	c := Serve[int, testResult](ctx, ln, pipeline.FromSlice(ctx, values...), CoordinatorConfig{
+		HeartbeatInterval: testHeartbeatInterval,
+		HeartbeatTimeout:  testHeartbeatTimeout,
+		OnLeave: func(worker string, reassigned int, err error) {
+			if left != nil {
+				left <- leave{worker, reassigned, err}
+			}
+		},
+	})
+	return c, ln.Addr().String()
+}
+
+// collect reads every outcome and checks each value was squared exactly once.
+func collect(t *testing.T, ctx context.Context, c *Coordinator[int, testResult], n int) []testResult {
+	t.Helper()
+	outcomes, err := pipeline.Collect(ctx, c.Out())
+	if err != nil {
+		t.Fatalf("collecting results: %v", err)
+	}
+	seen := make(map[int]bool)
+	var results []testResult
+	for _, o := range outcomes {
+		if o.Err != nil {
+			t.Fatalf("job failed: %v", o.Err)
+		}
+		seen[o.Value.Square] = true
+		results = append(results, o.Value)
+	}
+	for v := 1; v <= n; v++ {
+		if !seen[v*v] {
+			t.Fatalf("no result for job %d in %d outcomes", v, len(outcomes))
+		}
+	}
+	return results
+}
+
+// waitInFlight waits until the named worker holds n jobs.
+func waitInFlight(t *testing.T, c *Coordinator[int, testResult], name string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, w := range c.Workers() {
+			if w.Name == name && w.InFlight == n {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("worker %s never had %d jobs in flight: %+v", name, n, c.Workers())
+}
+
+func TestCreditWindow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const jobs, credits = 30, 3
+	c, addr := serve(t, ctx, jobs, nil)
+	startWorker(t, addr, "w1", "square", credits)
+
+	peak := 0
+	for _, r := range collect(t, ctx, c, jobs) {
+		if r.Running > credits {
+			t.Fatalf("worker ran %d jobs at once with %d credits", r.Running, credits)
+		}
+		peak = max(peak, r.Running)
+	}
+	if peak != credits {
+		t.Fatalf("worker ran at most %d jobs at once, want the full window of %d", peak, credits)
+	}
+}
+
+func TestKilledWorkerJobsReassigned(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const jobs = 10
+	left := make(chan leave, 4)
+	c, addr := serve(t, ctx, jobs, left)
+
+	// The first worker takes two jobs and never finishes them
+	stuck := startWorker(t, addr, "stuck", "hang", 2)
+	waitInFlight(t, c, "stuck", 2)
+	startWorker(t, addr, "healthy", "square", 2)
+	if err := stuck.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range collect(t, ctx, c, jobs) {
+		if r.Worker != "healthy" {
+			t.Fatalf("result from %s, want every job finished by healthy", r.Worker)
+		}
+	}
+	l := <-left
+	if l.worker != "stuck" || l.reassigned != 2 {
+		t.Fatalf("OnLeave(%s, %d, %v), want stuck with 2 jobs reassigned", l.worker, l.reassigned, l.err)
+	}
+}
+
+func TestSilentWorkerDropped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const jobs = 10
+	left := make(chan leave, 4)
+	c, addr := serve(t, ctx, jobs, left)
+
+	// Freeze the worker holding two jobs: the connection stays open but its
+	// heartbeats stop
+	frozen := startWorker(t, addr, "frozen", "hang", 2)
+	waitInFlight(t, c, "frozen", 2)
+	stopped := time.Now()
+	if err := frozen.Process.Signal(syscall.SIGSTOP); err != nil {
+		t.Fatal(err)
+	}
+
+	l := <-left
+	if l.worker != "frozen" || l.reassigned != 2 {
+		t.Fatalf("OnLeave(%s, %d, %v), want frozen with 2 jobs reassigned", l.worker, l.reassigned, l.err)
+	}
+	var netErr net.Error
+	if !errors.As(l.err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("frozen worker dropped with %v, want a heartbeat timeout", l.err)
+	}
+	if waited := time.Since(stopped); waited < testHeartbeatTimeout/2 {
+		t.Fatalf("frozen worker dropped after %v, before its heartbeats were due", waited)
+	}
+
+	startWorker(t, addr, "healthy", "square", 2)
+	collect(t, ctx, c, jobs)
+}
+
+func TestSlowConsumerKeepsWorkers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const jobs = 6
+	left := make(chan leave, 4)
+	c, addr := serve(t, ctx, jobs, left)
+	startWorker(t, addr, "w1", "square", 2)
+
+	// Stall the consumer for several heartbeat timeouts with results pending
+	first := <-c.Out()
+	if first.Err != nil {
+		t.Fatalf("job failed: %v", first.Err)
+	}
+	time.Sleep(3 * testHeartbeatTimeout)
+
+	rest, err := pipeline.Collect(ctx, c.Out())
+	if err != nil {
+		t.Fatalf("collecting results: %v", err)
+	}
+	if got := 1 + len(rest); got != jobs {
+		t.Fatalf("got %d results, want %d", got, jobs)
+	}
+	for _, o := range rest {
+		if o.Err != nil {
+			t.Fatalf("job failed: %v", o.Err)
+		}
+	}
+	select {
+	case l := <-left:
+		t.Fatalf("OnLeave(%s, %d, %v) while the consumer was slow, want the worker kept", l.worker, l.reassigned, l.err)
+	default:
+	}
+}
@@ -0,0 +1,157 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// WorkerConfig tunes the worker side of a connection.
+type WorkerConfig struct {
+	// Name identifies the worker to the coordinator. Defaults to the local
+	// address of the connection.
+	Name string
+	// Credits is how many jobs the worker runs at once. Defaults to 1.
+	Credits int
+
+	HeartbeatInterval time.Duration // Defaults to DefaultHeartbeatInterval
+	HeartbeatTimeout  time.Duration // Defaults to DefaultHeartbeatTimeout
+}
+
+// Work connects to the coordinator at addr and runs fn on the jobs it sends,
+// up to cfg.Credits at a time, streaming each result back. It returns nil when
+// the coordinator closes the connection because all work is done, and an
+// error if the connection fails or the coordinator stops sending heartbeats.
+func Work[In, Out any](ctx context.Context, addr string, cfg WorkerConfig, fn func(ctx context.Context, v In) (Out, error)) error {
+	if cfg.Credits < 1 {
+		cfg.Credits = 1
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.HeartbeatTimeout <= 0 {
+		cfg.HeartbeatTimeout = DefaultHeartbeatTimeout
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if cfg.Name == "" {
+		cfg.Name = conn.LocalAddr().String()
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// Results and heartbeats are written from several goroutines. The first
+	// failed write closes the connection, which ends the read loop.
+	var writeMu sync.Mutex
+	var writeErr error
+	write := func(kind byte, body any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(cfg.HeartbeatTimeout))
+		err := writeFrame(conn, kind, body)
+		if err != nil && writeErr == nil {
+			writeErr = err
+			cancel()
+		}
+		return err
+	}
+
+	if err := write(frameHello, hello{Name: cfg.Name, Credits: cfg.Credits}); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cfg.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if write(frameHeartbeat, nil) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(cfg.HeartbeatTimeout))
+		kind, body, err := readFrame(conn)
+		if err != nil {
+			cancel()
+			writeMu.Lock()
+			failed := writeErr
+			writeMu.Unlock()
+			switch {
+			case parent.Err() != nil:
+				return parent.Err()
+			case failed != nil:
+				return failed
+			case errors.Is(err, io.EOF):
+				return nil // The coordinator is done
+			default:
+				return err
+			}
+		}
+		if kind != frameJob {
+			continue // Heartbeats only refresh the deadline
+		}
+
+		var job jobFrame
+		if err := json.Unmarshal(body, &job); err != nil {
+			return fmt.Errorf("remote: decoding job frame: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := resultFrame{ID: job.ID}
+			if out, err := run(ctx, job.Payload, fn); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Payload = out
+			}
+			write(frameResult, result)
+		}()
+	}
+}
+
+// run decodes a job, calls fn on it and encodes the result, turning a panic
+// into an error so one bad job does not take the worker down.
+func run[In, Out any](ctx context.Context, payload json.RawMessage, fn func(ctx context.Context, v In) (Out, error)) (out json.RawMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	var v In
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil, fmt.Errorf("decoding job: %w", err)
+	}
+	result, err := fn(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how often and how fast a failed job is tried again.
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts, including the first; below 2 means no retry
+	BaseDelay   time.Duration // Wait before the second attempt
+	MaxDelay    time.Duration // Upper bound on the wait, zero for no bound
+	Multiplier  float64       // Growth of the wait per attempt, 2 when zero
+	Jitter      float64       // Fraction of each wait that is randomized, from 0 to 1
+
+	// Retryable reports whether an error is worth retrying. Nil retries every error.
+	Retryable func(error) bool
+}
+
+// backoff returns the wait after the given failed attempt (1-based).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	delay := float64(p.BaseDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay -= delay * p.Jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// Attempt records one failed try of a job.
+type Attempt struct {
+	Number int
+	At     time.Time
+	Err    error
+}
+
+// DeadLetter is a job that failed for good, with its error history.
+type DeadLetter[T any] struct {
+	Job      T
+	Attempts []Attempt
+}
+
+// Err returns the error of the last attempt.
+func (d DeadLetter[T]) Err() error {
+	if len(d.Attempts) == 0 {
+		return nil
+	}
+	return d.Attempts[len(d.Attempts)-1].Err
+}
+
+// RetryError is returned for a job that failed for good.
+type RetryError struct {
+	Attempts []Attempt
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("pipeline: giving up after %d attempts: %v", len(e.Attempts), e.Unwrap())
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// DeadLetterQueue keeps jobs that exhausted their retries, so they can be
+// inspected or replayed. It is safe for concurrent use.
+type DeadLetterQueue[T any] struct {
+	mu      sync.Mutex
+	letters []DeadLetter[T]
+}
+
+// NewDeadLetterQueue returns an empty queue.
+func NewDeadLetterQueue[T any]() *DeadLetterQueue[T] {
+	return &DeadLetterQueue[T]{}
+}
+
+// Add stores a dead letter.
+func (q *DeadLetterQueue[T]) Add(letter DeadLetter[T]) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.letters = append(q.letters, letter)
+}
+
+// Letters returns the stored dead letters, oldest first.
+func (q *DeadLetterQueue[T]) Letters() []DeadLetter[T] {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter[T](nil), q.letters...)
+}
+
+// Len returns the number of stored dead letters.
+func (q *DeadLetterQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.letters)
+}
+
+// Replay removes every stored dead letter and emits its job, so the jobs can
+// be fed back into a pipeline. Jobs not emitted before ctx is cancelled are
+// put back in the queue.
+func (q *DeadLetterQueue[T]) Replay(ctx context.Context) <-chan T {
+	q.mu.Lock()
+	letters := q.letters
+	q.letters = nil
+	q.mu.Unlock()
+
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+		for i, letter := range letters {
+			if !send(ctx, out, letter.Job) {
+				for _, rest := range letters[i:] {
+					q.Add(rest)
+				}
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Retry wraps a fallible worker so every job is tried according to policy.
+// Jobs that run out of attempts, or fail with a non-retryable error, are added
+// to dlq (which may be nil) and fail with a *RetryError. A job whose context
+// is cancelled stops at once, without being dead-lettered.
+func Retry[In, Out any](policy RetryPolicy, dlq *DeadLetterQueue[In], fn func(ctx context.Context, worker int, v In) (Out, error)) func(ctx context.Context, worker int, v In) (Out, error) {
+	return RetryEach(func(In) RetryPolicy { return policy }, dlq, fn)
+}
+
+// RetryEach is Retry with the policy chosen per job.
+func RetryEach[In, Out any](policyFor func(In) RetryPolicy, dlq *DeadLetterQueue[In], fn func(ctx context.Context, worker int, v In) (Out, error)) func(ctx context.Context, worker int, v In) (Out, error) {
+	return func(ctx context.Context, worker int, v In) (Out, error) {
+		policy := policyFor(v)
+		var attempts []Attempt
+		for n := 1; ; n++ {
+			out, err := safeCall(ctx, worker, v, fn)
+			if err == nil {
+				return out, nil
+			}
+			if ctx.Err() != nil {
+				// Cancelled rather than failed, so not a dead letter either
+				return out, err
+			}
+			attempts = append(attempts, Attempt{Number: n, At: time.Now(), Err: err})
+
+			if n >= policy.MaxAttempts || !policy.retryable(err) {
+				if dlq != nil {
+					dlq.Add(DeadLetter[In]{Job: v, Attempts: attempts})
+				}
+				return out, &RetryError{Attempts: attempts}
+			}
+
+			timer := time.NewTimer(policy.backoff(n))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return out, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// GatherPolicy decides when a scatter-gather call has enough responses.
+type GatherPolicy struct {
+	// Need is the number of successful responses to wait for. Zero waits for
+	// every backend to answer, even after some have failed.
+	Need int
+	// Quorum waits for a majority of the backends instead of Need.
+	Quorum bool
+	// Timeout gives up on the remaining backends after this long. Zero waits
+	// until the context is done.
+	Timeout time.Duration
+}
+
+var (
+	// GatherFirst returns as soon as one backend succeeds.
+	GatherFirst = GatherPolicy{Need: 1}
+	// GatherQuorum returns once a majority of the backends succeed.
+	GatherQuorum = GatherPolicy{Quorum: true}
+)
+
+// GatherFirstK returns once k backends succeed.
+func GatherFirstK(k int) GatherPolicy {
+	return GatherPolicy{Need: k}
+}
+
+// GatherAll waits for every backend, but no longer than timeout, and returns
+// whatever arrived in time.
+func GatherAll(timeout time.Duration) GatherPolicy {
+	return GatherPolicy{Timeout: timeout}
+}
+
+// needed returns how many successes the policy waits for out of n backends.
+func (p GatherPolicy) needed(n int) int {
+	switch {
+	case p.Quorum:
+		return n/2 + 1
+	case p.Need <= 0 || p.Need > n:
+		return n
+	default:
+		return p.Need
+	}
+}
+
+// Backend is one of the services a request is scattered to.
+type Backend[Req, Resp any] struct {
+	Name string // Identifies the backend in the results; defaults to "backend-<index>"
+	Call func(ctx context.Context, req Req) (Resp, error)
+}
+
+// Response is one backend's answer.
+type Response[Resp any] struct {
+	Backend string
+	Value   Resp
+	Err     error
+	Latency time.Duration
+}
+
+// GatherResult holds everything a scatter-gather call collected.
+type GatherResult[Resp any] struct {
+	Responses []Response[Resp] // Successful responses, in the order they arrived
+	Errors    map[string]error // Why each other backend has no response
+}
+
+// Values returns the successful response values, in the order they arrived.
+func (r GatherResult[Resp]) Values() []Resp {
+	values := make([]Resp, len(r.Responses))
+	for i, resp := range r.Responses {
+		values[i] = resp.Value
+	}
+	return values
+}
+
+var (
+	// ErrNotNeeded is recorded for backends whose call was cancelled because
+	// the policy was already satisfied.
+	ErrNotNeeded = errors.New("pipeline: call cancelled, enough responses were gathered")
+	// ErrUnreachable is recorded for backends whose call was cancelled because
+	// so many others failed that the policy could no longer be satisfied.
+	ErrUnreachable = errors.New("pipeline: call cancelled, too many backends failed")
+)
+
+// GatherTimeoutError is recorded for backends still running when the policy's
+// Timeout expired, and is the Cause of the GatherError if too few answered.
+type GatherTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *GatherTimeoutError) Error() string {
+	return fmt.Sprintf("pipeline: no response within the %v gather timeout", e.Timeout)
+}
+
+func (e *GatherTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// GatherError is returned when a scatter-gather call could not satisfy its
+// policy. The partial results are still returned alongside it.
+type GatherError struct {
+	Needed int
+	Got    int
+	Cause  error // The deadline or cancellation, or the backend errors that made the policy unreachable
+}
+
+func (e *GatherError) Error() string {
+	return fmt.Sprintf("pipeline: gathered %d of %d needed responses: %v", e.Got, e.Needed, e.Cause)
+}
+
+func (e *GatherError) Unwrap() error {
+	return e.Cause
+}
+
+// ScatterGather sends req to every backend at once and collects responses
+// until the policy is satisfied, then cancels the calls still running. Unless
+// it waits for every backend, it stops early once too many have failed for
+// the policy to be met. Calls that ignore their context are abandoned rather
+// than waited for.
+func ScatterGather[Req, Resp any](ctx context.Context, req Req, policy GatherPolicy, backends ...Backend[Req, Resp]) (GatherResult[Resp], error) {
+	result := GatherResult[Resp]{Errors: make(map[string]error)}
+	need := policy.needed(len(backends))
+	if len(backends) == 0 {
+		return result, nil
+	}
+
+	names := make([]string, len(backends))
+	for i, b := range backends {
+		names[i] = b.Name
+		if names[i] == "" {
+			names[i] = fmt.Sprintf("backend-%d", i)
+		}
+	}
+
+	callCtx, cancel := context.WithCancelCause(unobserved(ctx))
+	defer cancel(nil)
+	if policy.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		callCtx, cancelTimeout = context.WithTimeoutCause(callCtx, policy.Timeout, &GatherTimeoutError{Timeout: policy.Timeout})
+		defer cancelTimeout()
+	}
+
+	// Scatter: one worker per backend, fanned back in as responses arrive
+	indexes := make([]int, len(backends))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	calls := FanOut(callCtx, FromSlice(callCtx, indexes...), len(backends), func(ctx context.Context, _ int, i int) Response[Resp] {
+		start := time.Now()
+		value, err := abandon(ctx, func(ctx context.Context) (Resp, error) {
+			return safeCall(ctx, i, req, func(ctx context.Context, _ int, req Req) (Resp, error) {
+				return backends[i].Call(ctx, req)
+			})
+		})
+		return Response[Resp]{Backend: names[i], Value: value, Err: err, Latency: time.Since(start)}
+	})
+	responses := Merge(callCtx, calls...)
+
+	// Gather until the policy is met, or can no longer be
+	pending := make(map[string]bool, len(names))
+	for _, name := range names {
+		pending[name] = true
+	}
+	failed, tolerated := 0, len(backends)-need
+	if policy.Need <= 0 && !policy.Quorum {
+		tolerated = len(backends) // Hear from everyone
+	}
+	for len(result.Responses) < need && failed <= tolerated && len(pending) > 0 {
+		r, ok := receive(callCtx, responses)
+		if !ok {
+			break
+		}
+		delete(pending, r.Backend)
+		if r.Err != nil {
+			result.Errors[r.Backend] = r.Err
+			failed++
+			continue
+		}
+		result.Responses = append(result.Responses, r)
+	}
+
+	var err error
+	reason := ErrNotNeeded
+	if got := len(result.Responses); got < need {
+		cause := context.Cause(callCtx)
+		reason = cause
+		if cause == nil {
+			cause, reason = backendErrors(result.Errors), ErrUnreachable
+		}
+		err = &GatherError{Needed: need, Got: got, Cause: cause}
+	}
+	cancel(reason)
+	for name := range pending {
+		result.Errors[name] = reason
+	}
+	return result, err
+}
+
+// backendErrors joins the backend errors in name order.
+func backendErrors(errs map[string]error) error {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	joined := make([]error, len(names))
+	for i, name := range names {
+		joined[i] = fmt.Errorf("%s: %w", name, errs[name])
+	}
+	return errors.Join(joined...)
+}
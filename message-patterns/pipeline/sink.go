@@ -0,0 +1,27 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// Sink calls fn for every value of in until in is closed. It returns
+// ctx.Err() if the context is cancelled first.
+func Sink[T any](ctx context.Context, in <-chan T, fn func(T)) error {
+	for {
+		v, ok := receive(ctx, in)
+		if !ok {
+			return ctx.Err()
+		}
+		start := time.Now()
+		fn(v)
+		observe(ctx, start)
+	}
+}
+
+// Collect gathers every value of in into a slice.
+func Collect[T any](ctx context.Context, in <-chan T) ([]T, error) {
+	var items []T
+	err := Sink(ctx, in, func(v T) { items = append(items, v) })
+	return items, err
+}
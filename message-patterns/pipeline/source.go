@@ -0,0 +1,40 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// Source emits the values returned by next, called with 0, 1, 2, ...,
+// until next reports false or ctx is cancelled.
+func Source[T any](ctx context.Context, next func(i int) (T, bool)) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+		for i := 0; ; i++ {
+			start := time.Now()
+			v, ok := next(i)
+			if !ok {
+				return
+			}
+			observe(ctx, start)
+			if !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// FromSlice emits items in order.
+func FromSlice[T any](ctx context.Context, items ...T) <-chan T {
+	return Source(ctx, func(i int) (T, bool) {
+		if i >= len(items) {
+			var zero T
+			return zero, false
+		}
+		return items[i], true
+	})
+}
@@ -0,0 +1,402 @@
+package spec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Builtins returns a Registry with the standard types:
+//
+//	sources  range {start, count, step, interval}  numbers start, start+step, ...
+//	         values {values}                       the listed JSON values
+//	         lines {path}                          lines of a file, or stdin for "" or "-"
+//	stages   add {value}, multiply {by}, square    arithmetic on numbers
+//	         filter {min, max, divisible_by}       keeps numbers that match all limits set
+//	         parse-number                          strings to numbers, failing on anything else
+//	         split {separator}                     strings to their words, or fields if set
+//	         upper                                 strings to upper case
+//	         sleep {duration, jitter}              passes values on after a delay
+//	sinks    print {prefix}                        writes each value to stdout
+//	         sum {label}                           prints the total of all numbers at the end
+//	         file {path}                           writes each value as a line of JSON
+//	         discard                               drops everything
+//
+// Durations are strings such as "150ms". Numbers are float64, as in JSON.
+// File paths may refer to environment variables, and $TMPDIR is always the
+// temporary directory, as returned by os.TempDir.
+func Builtins() *Registry {
+	r := NewRegistry()
+
+	r.RegisterSource("range", rangeSource)
+	r.RegisterSource("values", valuesSource)
+	r.RegisterSource("lines", linesSource)
+
+	r.RegisterStage("add", numberStage(func(p struct{ Value float64 }) (func(float64) float64, error) {
+		return func(x float64) float64 { return x + p.Value }, nil
+	}))
+	r.RegisterStage("multiply", numberStage(func(p struct{ By float64 }) (func(float64) float64, error) {
+		return func(x float64) float64 { return x * p.By }, nil
+	}))
+	r.RegisterStage("square", numberStage(func(struct{}) (func(float64) float64, error) {
+		return func(x float64) float64 { return x * x }, nil
+	}))
+	r.RegisterStage("filter", filterStage)
+	r.RegisterStage("parse-number", stringStage(func(s string) ([]any, error) {
+		x, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("not a number: %q", s)
+		}
+		return []any{x}, nil
+	}))
+	r.RegisterStage("upper", stringStage(func(s string) ([]any, error) {
+		return []any{strings.ToUpper(s)}, nil
+	}))
+	r.RegisterStage("split", splitStage)
+	r.RegisterStage("sleep", sleepStage)
+
+	r.RegisterSink("print", printSink)
+	r.RegisterSink("sum", sumSink)
+	r.RegisterSink("file", fileSink)
+	r.RegisterSink("discard", func(params Params) (Sink, error) {
+		if err := params.Decode(&struct{}{}); err != nil {
+			return nil, err
+		}
+		return SinkFunc(func(any) error { return nil }), nil
+	})
+
+	return r
+}
+
+// Duration is a time.Duration written in JSON as a string such as "1.5s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"100ms\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func rangeSource(params Params) (Source, error) {
+	p := struct {
+		Start    float64
+		Count    int
+		Step     *float64
+		Interval Duration
+	}{}
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Count <= 0 {
+		return nil, errors.New("count must be positive")
+	}
+	step := 1.0
+	if p.Step != nil {
+		step = *p.Step
+	}
+
+	return func(ctx context.Context, emit func(any) bool) error {
+		for i := 0; i < p.Count; i++ {
+			if i > 0 && p.Interval > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(time.Duration(p.Interval)):
+				}
+			}
+			if !emit(p.Start + float64(i)*step) {
+				return nil
+			}
+		}
+		return nil
+	}, nil
+}
+
+func valuesSource(params Params) (Source, error) {
+	var p struct{ Values []any }
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	if len(p.Values) == 0 {
+		return nil, errors.New("values must not be empty")
+	}
+
+	return func(ctx context.Context, emit func(any) bool) error {
+		for _, v := range p.Values {
+			if !emit(v) {
+				return nil
+			}
+		}
+		return nil
+	}, nil
+}
+
+func linesSource(params Params) (Source, error) {
+	var p struct{ Path string }
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, emit func(any) bool) error {
+		var r io.Reader = os.Stdin
+		if p.Path != "" && p.Path != "-" {
+			f, err := os.Open(p.Path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if !emit(scanner.Text()) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}, nil
+}
+
+// numberStage builds a stage applying a function of one number, made from
+// parameters of type P.
+func numberStage[P any](build func(P) (func(float64) float64, error)) StageFactory {
+	return func(params Params) (Stage, error) {
+		var p P
+		if err := params.Decode(&p); err != nil {
+			return nil, err
+		}
+		fn, err := build(p)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, v any) ([]any, error) {
+			x, err := toNumber(v)
+			if err != nil {
+				return nil, err
+			}
+			return []any{fn(x)}, nil
+		}, nil
+	}
+}
+
+// stringStage builds a parameterless stage over strings.
+func stringStage(fn func(string) ([]any, error)) StageFactory {
+	return func(params Params) (Stage, error) {
+		if err := params.Decode(&struct{}{}); err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, v any) ([]any, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %T", v)
+			}
+			return fn(s)
+		}, nil
+	}
+}
+
+func filterStage(params Params) (Stage, error) {
+	var p struct {
+		Min, Max    *float64
+		DivisibleBy float64 `json:"divisible_by"`
+	}
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Min == nil && p.Max == nil && p.DivisibleBy == 0 {
+		return nil, errors.New("set at least one of min, max or divisible_by")
+	}
+
+	return func(ctx context.Context, v any) ([]any, error) {
+		x, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		if (p.Min != nil && x < *p.Min) || (p.Max != nil && x > *p.Max) ||
+			(p.DivisibleBy != 0 && math.Mod(x, p.DivisibleBy) != 0) {
+			return nil, nil
+		}
+		return []any{v}, nil
+	}, nil
+}
+
+func splitStage(params Params) (Stage, error) {
+	var p struct{ Separator string }
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	return stringStage(func(s string) ([]any, error) {
+		var parts []string
+		if p.Separator == "" {
+			parts = strings.Fields(s)
+		} else {
+			parts = strings.Split(s, p.Separator)
+		}
+		out := make([]any, len(parts))
+		for i, part := range parts {
+			out[i] = part
+		}
+		return out, nil
+	})(nil)
+}
+
+func sleepStage(params Params) (Stage, error) {
+	var p struct{ Duration, Jitter Duration }
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Duration <= 0 {
+		return nil, errors.New("duration must be positive")
+	}
+
+	return func(ctx context.Context, v any) ([]any, error) {
+		d := time.Duration(p.Duration)
+		if p.Jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(p.Jitter)))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d):
+			return []any{v}, nil
+		}
+	}, nil
+}
+
+func printSink(params Params) (Sink, error) {
+	var p struct{ Prefix string }
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	return SinkFunc(func(v any) error {
+		_, err := fmt.Printf("%s%v\n", p.Prefix, v)
+		return err
+	}), nil
+}
+
+type sum struct {
+	label string
+	total float64
+}
+
+func (s *sum) Consume(v any) error {
+	x, err := toNumber(v)
+	s.total += x
+	return err
+}
+
+func (s *sum) Close() error {
+	_, err := fmt.Printf("%s%v\n", s.label, s.total)
+	return err
+}
+
+func sumSink(params Params) (Sink, error) {
+	p := struct{ Label string }{Label: "Sum: "}
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	return &sum{label: p.Label}, nil
+}
+
+type jsonLines struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonLines) Consume(v any) error { return j.enc.Encode(v) }
+
+func (j *jsonLines) Close() error {
+	err := j.w.Flush()
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func fileSink(params Params) (Sink, error) {
+	var p struct{ Path string }
+	if err := params.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	path := os.Expand(p.Path, func(name string) string {
+		if name == "TMPDIR" {
+			return os.TempDir()
+		}
+		return os.Getenv(name)
+	})
+
+	// The file is created lazily, so validating a definition has no side effects
+	return &lazySink{open: func() (Sink, error) {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w := bufio.NewWriter(f)
+		return &jsonLines{f: f, w: w, enc: json.NewEncoder(w)}, nil
+	}}, nil
+}
+
+// lazySink opens its underlying sink on the first value, or on Close if there
+// were none.
+type lazySink struct {
+	open func() (Sink, error)
+	sink Sink
+}
+
+func (l *lazySink) Consume(v any) error {
+	if l.sink == nil {
+		sink, err := l.open()
+		if err != nil {
+			return err
+		}
+		l.sink = sink
+	}
+	return l.sink.Consume(v)
+}
+
+func (l *lazySink) Close() error {
+	if l.sink == nil {
+		sink, err := l.open()
+		if err != nil {
+			return err
+		}
+		l.sink = sink
+	}
+	return l.sink.Close()
+}
+
+// toNumber accepts the numeric types a source or stage may produce.
+func toNumber(v any) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case int:
+		return float64(x), nil
+	case json.Number:
+		return x.Float64()
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", v)
+	}
+}
@@ -0,0 +1,121 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Source produces values by calling emit until it runs out or emit returns
+// false, which it does once the run is stopping.
+type Source func(ctx context.Context, emit func(v any) bool) error
+
+// Stage turns one value into any number of values: none to filter it out,
+// several to split it. It is called from all of the stage's workers at once.
+type Stage func(ctx context.Context, v any) ([]any, error)
+
+// Sink consumes the values of a pipeline. Consume is only called from one
+// goroutine, and Close once the sink's inputs are drained.
+type Sink interface {
+	Consume(v any) error
+	Close() error
+}
+
+// SinkFunc adapts a function to a Sink with nothing to close.
+type SinkFunc func(v any) error
+
+func (f SinkFunc) Consume(v any) error { return f(v) }
+func (f SinkFunc) Close() error        { return nil }
+
+// Factories build a node's implementation from its parameters. They should
+// reject parameters that are missing or out of range, as they are called when
+// a Definition is validated.
+type (
+	SourceFactory func(params Params) (Source, error)
+	StageFactory  func(params Params) (Stage, error)
+	SinkFactory   func(params Params) (Sink, error)
+)
+
+// Registry maps type names to the factories that implement them.
+type Registry struct {
+	sources map[string]SourceFactory
+	stages  map[string]StageFactory
+	sinks   map[string]SinkFactory
+}
+
+// NewRegistry returns an empty Registry. Builtins returns one with the
+// standard types already registered.
+func NewRegistry() *Registry {
+	return &Registry{
+		sources: make(map[string]SourceFactory),
+		stages:  make(map[string]StageFactory),
+		sinks:   make(map[string]SinkFactory),
+	}
+}
+
+// RegisterSource adds a source type, replacing any of the same name.
+func (r *Registry) RegisterSource(name string, f SourceFactory) {
+	r.sources[name] = f
+}
+
+// RegisterStage adds a stage type, replacing any of the same name.
+func (r *Registry) RegisterStage(name string, f StageFactory) {
+	r.stages[name] = f
+}
+
+// RegisterSink adds a sink type, replacing any of the same name.
+func (r *Registry) RegisterSink(name string, f SinkFactory) {
+	r.sinks[name] = f
+}
+
+// Types returns the registered type names of a kind, sorted.
+func (r *Registry) Types(kind Kind) []string {
+	var names []string
+	switch kind {
+	case KindSource:
+		for name := range r.sources {
+			names = append(names, name)
+		}
+	case KindStage:
+		for name := range r.stages {
+			names = append(names, name)
+		}
+	case KindSink:
+		for name := range r.sinks {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// build looks up the node's type and builds its implementation.
+func (r *Registry) build(n *node) error {
+	var err error
+	found := false
+	switch n.kind {
+	case KindSource:
+		var f SourceFactory
+		if f, found = r.sources[n.Type]; found {
+			n.source, err = f(n.Params)
+		}
+	case KindStage:
+		var f StageFactory
+		if f, found = r.stages[n.Type]; found {
+			n.stage, err = f(n.Params)
+		}
+	case KindSink:
+		var f SinkFactory
+		if f, found = r.sinks[n.Type]; found {
+			n.sink, err = f(n.Params)
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("unknown %s type %q (known: %v)", n.kind, n.Type, r.Types(n.kind))
+	}
+	if err != nil {
+		return fmt.Errorf("params: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// NodeStats is what one node did during a run.
+type NodeStats struct {
+	Name        string
+	Type        string
+	Kind        Kind
+	Parallelism int
+	In          uint64        // Values received; always zero for sources
+	Out         uint64        // Values emitted; always zero for sinks
+	Errors      uint64        // Values that failed
+	Busy        time.Duration // Time spent in the implementation, summed over workers
+	FirstError  error
+}
+
+// Summary reports a finished run.
+type Summary struct {
+	Name    string
+	Nodes   []NodeStats // In the order they were declared
+	Elapsed time.Duration
+	Err     error // Why the run stopped early, if it did
+}
+
+// Print writes the summary as a table.
+func (s *Summary) Print(w io.Writer) {
+	status := "finished"
+	if s.Err != nil {
+		status = "stopped"
+	}
+	fmt.Fprintf(w, "Pipeline %q %s in %v\n", s.Name, status, s.Elapsed.Round(time.Millisecond))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tTYPE\tWORKERS\tIN\tOUT\tERRORS\tBUSY")
+	for _, n := range s.Nodes {
+		in, out := fmt.Sprint(n.In), fmt.Sprint(n.Out)
+		if n.Kind == KindSource {
+			in = "-"
+		}
+		if n.Kind == KindSink {
+			out = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%d\t%v\n",
+			n.Kind, n.Name, n.Type, n.Parallelism, in, out, n.Errors, n.Busy.Round(time.Millisecond))
+	}
+	tw.Flush()
+
+	for _, n := range s.Nodes {
+		if n.FirstError != nil {
+			fmt.Fprintf(w, "First error in %s %s: %v\n", n.Kind, n.Name, n.FirstError)
+		}
+	}
+	if s.Err != nil {
+		fmt.Fprintf(w, "Stopped by: %v\n", s.Err)
+	}
+}
+
+// counters are updated by a node's goroutines while it runs.
+type counters struct {
+	in, out, errors atomic.Uint64
+	busy            atomic.Int64
+	mu              sync.Mutex
+	first           error
+}
+
+func (c *counters) fail(err error) {
+	c.errors.Add(1)
+	c.mu.Lock()
+	if c.first == nil {
+		c.first = err
+	}
+	c.mu.Unlock()
+}
+
+func (c *counters) firstError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.first
+}
+
+func (c *counters) timed(start time.Time) {
+	c.busy.Add(int64(time.Since(start)))
+}
+
+// recovered turns a panic in a node's implementation into an error, so a bad
+// value fails like any other instead of taking the whole process down.
+func recovered(err *error) {
+	if r := recover(); r != nil {
+		*err = &pipeline.PanicError{Value: r, Stack: debug.Stack()}
+	}
+}
+
+// Run validates def against reg and runs it until every source is exhausted
+// and every sink has drained, or until ctx is cancelled or a node with
+// on_error "fail" fails. The summary is returned even when the run stops
+// early; the error is nil only if it ran to completion.
+func Run(ctx context.Context, def *Definition, reg *Registry) (*Summary, error) {
+	order, err := compile(def, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	start := time.Now()
+
+	stats := make(map[string]*counters, len(order))
+	outputs := make(map[string][]<-chan any) // One channel per consumer
+	var wg sync.WaitGroup
+
+	// take hands out the next unclaimed output channel of a node
+	take := func(name string) <-chan any {
+		ch := outputs[name][0]
+		outputs[name] = outputs[name][1:]
+		return ch
+	}
+
+	for _, n := range order {
+		c := &counters{}
+		stats[n.Name] = c
+
+		// failed records a value's error and stops the run if the node says so
+		failed := func(err error) {
+			c.fail(err)
+			if n.OnError == "fail" {
+				cancel(fmt.Errorf("%s %s: %w", n.kind, n.Name, err))
+			}
+		}
+
+		var in <-chan any
+		if n.kind != KindSource {
+			ins := make([]<-chan any, len(n.Inputs))
+			for i, name := range n.Inputs {
+				ins[i] = take(name)
+			}
+			in = ins[0]
+			if len(ins) > 1 {
+				in = pipeline.Merge(ctx, ins...)
+			}
+		}
+
+		var out <-chan any
+		switch n.kind {
+		case KindSource:
+			ch := make(chan any)
+			out = ch
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(ch)
+				defer c.timed(time.Now())
+				err := func() (err error) {
+					defer recovered(&err)
+					return n.source(ctx, func(v any) bool {
+						select {
+						case <-ctx.Done():
+							return false
+						case ch <- v:
+							c.out.Add(1)
+							return true
+						}
+					})
+				}()
+				if err != nil && ctx.Err() == nil {
+					failed(err)
+				}
+			}()
+
+		case KindStage:
+			workers := pipeline.FanOut(ctx, in, n.Parallelism, func(ctx context.Context, _ int, v any) []any {
+				c.in.Add(1)
+				defer c.timed(time.Now())
+				vs, err := func() (vs []any, err error) {
+					defer recovered(&err)
+					return n.stage(ctx, v)
+				}()
+				if err != nil {
+					if ctx.Err() == nil {
+						failed(err)
+					}
+					return nil
+				}
+				c.out.Add(uint64(len(vs)))
+				return vs
+			})
+			out = pipeline.Unbatch(ctx, pipeline.Merge(ctx, workers...))
+
+		case KindSink:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pipeline.Sink(ctx, in, func(v any) {
+					c.in.Add(1)
+					defer c.timed(time.Now())
+					err := func() (err error) {
+						defer recovered(&err)
+						return n.sink.Consume(v)
+					}()
+					if err != nil {
+						failed(err)
+					}
+				})
+				err := func() (err error) {
+					defer recovered(&err)
+					return n.sink.Close()
+				}()
+				if err != nil {
+					failed(err)
+				}
+			}()
+			continue
+		}
+
+		if len(n.consumers) == 1 {
+			outputs[n.Name] = []<-chan any{buffer(ctx, out, n.Buffer)}
+			continue
+		}
+		configs := make([]pipeline.OutputConfig, len(n.consumers))
+		for i := range configs {
+			configs[i] = pipeline.OutputConfig{Policy: pipeline.Block, Buffer: n.Buffer}
+		}
+		outputs[n.Name] = pipeline.Broadcast(ctx, out, configs...).Outs()
+	}
+
+	wg.Wait()
+	summary := &Summary{Name: def.Name, Elapsed: time.Since(start), Err: context.Cause(ctx)}
+
+	for _, n := range declared(def, order) {
+		c := stats[n.Name]
+		summary.Nodes = append(summary.Nodes, NodeStats{
+			Name:        n.Name,
+			Type:        n.Type,
+			Kind:        n.kind,
+			Parallelism: n.Parallelism,
+			In:          c.in.Load(),
+			Out:         c.out.Load(),
+			Errors:      c.errors.Load(),
+			Busy:        time.Duration(c.busy.Load()),
+			FirstError:  c.firstError(),
+		})
+	}
+	return summary, summary.Err
+}
+
+// buffer decouples a node from its consumer with a queue of n values.
+func buffer(ctx context.Context, in <-chan any, n int) <-chan any {
+	if n <= 0 {
+		return in
+	}
+	out := make(chan any, n)
+	go func() {
+		defer close(out)
+		pipeline.Sink(ctx, in, func(v any) {
+			select {
+			case <-ctx.Done():
+			case out <- v:
+			}
+		})
+	}()
+	return out
+}
+
+// declared returns the nodes in the order the definition lists them.
+func declared(def *Definition, order []*node) []*node {
+	byName := make(map[string]*node, len(order))
+	for _, n := range order {
+		byName[n.Name] = n
+	}
+	var nodes []*node
+	for _, group := range [][]Node{def.Sources, def.Stages, def.Sinks} {
+		for _, n := range group {
+			name := n.Name
+			if name == "" {
+				name = n.Type
+			}
+			nodes = append(nodes, byName[name])
+		}
+	}
+	return nodes
+}
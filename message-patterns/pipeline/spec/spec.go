@@ -0,0 +1,302 @@
+// Package spec builds and runs pipelines described in JSON or YAML instead of
+// Go code. A Definition names its sources, stages and sinks by type, and a
+// Registry maps each type to the code that implements it. YAML is read by a
+// small parser of its own, which rejects anchors, tags and multi-line strings.
+//
+// Nodes are joined by name: each stage and sink lists the nodes it reads
+// from, and defaults to the last source or stage declared before it. A node
+// with several inputs merges them, and a node read by several others
+// broadcasts to all of them.
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Definition describes a pipeline graph.
+type Definition struct {
+	Name    string `json:"name"`
+	Sources []Node `json:"sources"`
+	Stages  []Node `json:"stages"`
+	Sinks   []Node `json:"sinks"`
+}
+
+// Node is one source, stage or sink in a Definition.
+type Node struct {
+	// Name identifies the node to others. Defaults to its type.
+	Name string `json:"name,omitempty"`
+	// Type selects the implementation from the Registry.
+	Type string `json:"type"`
+	// Inputs names the nodes this one reads from. Defaults to the node
+	// declared before it; sources take none.
+	Inputs []string `json:"inputs,omitempty"`
+	// Parallelism is the number of workers a stage runs. Defaults to 1.
+	Parallelism int `json:"parallelism,omitempty"`
+	// Buffer is the capacity of the node's output channel.
+	Buffer int `json:"buffer,omitempty"`
+	// OnError is "skip" to drop values that fail and carry on (the default),
+	// or "fail" to stop the whole run at the first error.
+	OnError string `json:"on_error,omitempty"`
+	// Params configures the implementation; see the type's documentation.
+	Params Params `json:"params,omitempty"`
+}
+
+// Kind tells sources, stages and sinks apart.
+type Kind string
+
+const (
+	KindSource Kind = "source"
+	KindStage  Kind = "stage"
+	KindSink   Kind = "sink"
+)
+
+// Params holds the raw JSON parameters of a node.
+type Params json.RawMessage
+
+// MarshalJSON returns p unchanged.
+func (p Params) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+// UnmarshalJSON keeps a copy of the raw parameters.
+func (p *Params) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+// Decode unmarshals the parameters into v, rejecting unknown fields so that a
+// misspelt parameter fails validation instead of being ignored.
+func (p Params) Decode(v any) error {
+	if len(p) == 0 || string(p) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// Load reads a Definition from a file, as YAML if its extension is .yaml or
+// .yml and as JSON otherwise.
+func Load(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		return ParseYAML(data)
+	}
+	return Parse(data)
+}
+
+// Parse decodes a Definition from JSON.
+func Parse(data []byte) (*Definition, error) {
+	var def Definition
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&def); err != nil {
+		return nil, fmt.Errorf("spec: %w", err)
+	}
+	return &def, nil
+}
+
+// ParseYAML decodes a Definition from YAML. Keys are the same as in JSON.
+func ParseYAML(data []byte) (*Definition, error) {
+	js, err := yamlToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("spec: %w", err)
+	}
+	return Parse(js)
+}
+
+// Validate checks the graph and the parameters of every node against reg,
+// reporting every problem found rather than only the first.
+func (d *Definition) Validate(reg *Registry) error {
+	_, err := compile(d, reg)
+	return err
+}
+
+// node is a validated Node with its implementation built.
+type node struct {
+	Node
+	kind      Kind
+	source    Source
+	stage     Stage
+	sink      Sink
+	consumers []string
+}
+
+// compile validates d and returns its nodes in an order where every node
+// comes after all of its inputs.
+func compile(d *Definition, reg *Registry) ([]*node, error) {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(d.Sources) == 0 {
+		fail("no sources")
+	}
+	if len(d.Sinks) == 0 {
+		fail("no sinks")
+	}
+
+	var nodes []*node
+	byName := make(map[string]*node)
+	previous := ""
+	add := func(kind Kind, i int, n Node) {
+		if n.Name == "" {
+			n.Name = n.Type
+		}
+		label := fmt.Sprintf("%s %d (%s)", kind, i+1, n.Name)
+		if n.Type == "" {
+			fail("%s: missing type", label)
+		}
+		if _, dup := byName[n.Name]; dup {
+			fail("%s: duplicate name, give each node of this type its own name", label)
+		}
+
+		switch kind {
+		case KindSource:
+			if len(n.Inputs) > 0 {
+				fail("%s: sources take no inputs", label)
+			}
+		default:
+			if len(n.Inputs) == 0 && previous != "" {
+				n.Inputs = []string{previous}
+			}
+			if len(n.Inputs) == 0 {
+				fail("%s: no inputs", label)
+			}
+		}
+		if kind != KindStage && n.Parallelism != 0 {
+			fail("%s: only stages have parallelism", label)
+		}
+		if n.Parallelism < 0 {
+			fail("%s: parallelism must be positive", label)
+		}
+		if n.Parallelism == 0 {
+			n.Parallelism = 1
+		}
+		if n.Buffer < 0 {
+			fail("%s: buffer must not be negative", label)
+		}
+		switch n.OnError {
+		case "":
+			n.OnError = "skip"
+		case "skip", "fail":
+		default:
+			fail("%s: on_error must be \"skip\" or \"fail\", not %q", label, n.OnError)
+		}
+
+		nd := &node{Node: n, kind: kind}
+		if n.Type != "" {
+			if err := reg.build(nd); err != nil {
+				fail("%s: %v", label, err)
+			}
+		}
+		nodes = append(nodes, nd)
+		byName[n.Name] = nd
+		if kind != KindSink {
+			previous = n.Name // Sinks have no output to default to
+		}
+	}
+
+	for i, n := range d.Sources {
+		add(KindSource, i, n)
+	}
+	for i, n := range d.Stages {
+		add(KindStage, i, n)
+	}
+	for i, n := range d.Sinks {
+		add(KindSink, i, n)
+	}
+
+	for _, n := range nodes {
+		for _, input := range n.Inputs {
+			from, ok := byName[input]
+			switch {
+			case !ok:
+				fail("%s %s: unknown input %q", n.kind, n.Name, input)
+			case from.kind == KindSink:
+				fail("%s %s: input %q is a sink", n.kind, n.Name, input)
+			default:
+				from.consumers = append(from.consumers, n.Name)
+			}
+		}
+	}
+	for _, n := range nodes {
+		if n.kind != KindSink && len(n.consumers) == 0 {
+			fail("%s %s: output is not read by any stage or sink", n.kind, n.Name)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errs: errs}
+	}
+
+	order, err := topoSort(nodes, byName)
+	if err != nil {
+		return nil, &ValidationError{Errs: []error{err}}
+	}
+	return order, nil
+}
+
+// topoSort orders nodes so that inputs come first, and rejects cycles.
+func topoSort(nodes []*node, byName map[string]*node) ([]*node, error) {
+	pending := make(map[string]int, len(nodes))
+	var ready []*node
+	for _, n := range nodes {
+		pending[n.Name] = len(n.Inputs)
+		if len(n.Inputs) == 0 {
+			ready = append(ready, n)
+		}
+	}
+
+	var order []*node
+	for len(ready) > 0 {
+		n := ready[0]
+		ready = ready[1:]
+		order = append(order, n)
+		for _, c := range n.consumers {
+			pending[c]--
+			if pending[c] == 0 {
+				ready = append(ready, byName[c])
+			}
+		}
+	}
+
+	if len(order) < len(nodes) {
+		var cycle []string
+		for _, n := range nodes {
+			if pending[n.Name] > 0 {
+				cycle = append(cycle, n.Name)
+			}
+		}
+		return nil, fmt.Errorf("cycle between %v", cycle)
+	}
+	return order, nil
+}
+
+// ValidationError lists every problem found in a Definition.
+type ValidationError struct {
+	Errs []error
+}
+
+func (e *ValidationError) Error() string {
+	msg := "spec: invalid pipeline:"
+	for _, err := range e.Errs {
+		msg += "\n  " + err.Error()
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Errs
+}
@@ -0,0 +1,118 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// normalized re-encodes a definition so that two with the same content
+// compare equal, however their parameters were spelt.
+func normalized(t *testing.T, def *Definition) any {
+	t.Helper()
+	data, err := json.Marshal(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestYAMLExampleMatchesJSON(t *testing.T) {
+	fromJSON, err := Load("../cmd/pipeline/examples/squares.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := Load("../cmd/pipeline/examples/squares.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := normalized(t, fromJSON), normalized(t, fromYAML); !reflect.DeepEqual(a, b) {
+		t.Fatalf("YAML definition %v\ndiffers from JSON %v", b, a)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	def, err := ParseYAML([]byte(`
+---
+name: 'it''s quoted' # a comment
+sources:
+- type: values
+  params:
+    values: [1, -2.5, "#3", 'x, y', {a: null}, true, ~]
+sinks:
+  - {type: discard}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Name != "it's quoted" || len(def.Sources) != 1 || len(def.Sinks) != 1 || def.Sinks[0].Type != "discard" {
+		t.Fatalf("parsed %+v", def)
+	}
+	var p struct{ Values []any }
+	if err := def.Sources[0].Params.Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	want := []any{1.0, -2.5, "#3", "x, y", map[string]any{"a": nil}, true, nil}
+	if !reflect.DeepEqual(p.Values, want) {
+		t.Fatalf("values = %#v, want %#v", p.Values, want)
+	}
+}
+
+func TestParseYAMLRejectsUnsupported(t *testing.T) {
+	for _, doc := range []string{
+		"name: &anchor x",
+		"name: |\n  multi\n  line",
+		"name: x\n\tsources: []",
+		"name: x\nname: y",
+		"name: x\n---\nname: y",
+		"name: [1, 2",
+		"sources:\n  - type: values\n   params: {}",
+	} {
+		if _, err := ParseYAML([]byte(doc)); err == nil {
+			t.Errorf("ParseYAML(%q) succeeded, want an error", doc)
+		}
+	}
+}
+
+func TestRunRecoversPanics(t *testing.T) {
+	reg := Builtins()
+	reg.RegisterStage("explode", func(Params) (Stage, error) {
+		return func(ctx context.Context, v any) ([]any, error) {
+			if v.(float64) == 2 {
+				panic("boom")
+			}
+			return []any{v}, nil
+		}, nil
+	})
+	def, err := ParseYAML([]byte(`
+name: panics
+sources: [{type: range, params: {start: 1, count: 3}}]
+stages: [{type: explode}]
+sinks: [{type: discard}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := Run(context.Background(), def, reg)
+	if err != nil {
+		t.Fatalf("Run() = %v, want the panicking value skipped", err)
+	}
+	stage := summary.Nodes[1]
+	var panicked *pipeline.PanicError
+	if stage.Errors != 1 || !errors.As(stage.FirstError, &panicked) || !strings.Contains(panicked.Error(), "boom") {
+		t.Fatalf("stage errors %d, first %v, want one recovered panic", stage.Errors, stage.FirstError)
+	}
+	if sink := summary.Nodes[2]; sink.In != 2 {
+		t.Fatalf("sink received %d values, want 2", sink.In)
+	}
+}
@@ -0,0 +1,428 @@
+package spec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The standard library has no YAML parser, so definitions written in YAML are
+// read by a small one that covers what a Definition needs: block and flow
+// mappings and sequences, quoted and plain scalars, and comments. Anchors,
+// aliases, tags, multi-line strings and multiple documents are rejected. The
+// result is converted to JSON and decoded like any other definition.
+
+// yamlLine is one line holding content, with its indentation and any comment
+// removed.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// yamlToJSON converts a YAML document to the equivalent JSON.
+func yamlToJSON(data []byte) ([]byte, error) {
+	p := &yamlParser{}
+	if err := p.split(string(data)); err != nil {
+		return nil, err
+	}
+	if len(p.lines) == 0 {
+		return nil, errors.New("yaml: empty document")
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf(p.lines[p.pos], "unexpected indentation")
+	}
+	return json.Marshal(v)
+}
+
+func (p *yamlParser) errorf(line yamlLine, format string, args ...any) error {
+	return fmt.Errorf("yaml: line %d: %s", line.num, fmt.Sprintf(format, args...))
+}
+
+// split breaks the document into lines, dropping blank lines, comments and
+// the document markers.
+func (p *yamlParser) split(doc string) error {
+	for i, raw := range strings.Split(doc, "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		text := strings.TrimLeft(raw, " ")
+		line := yamlLine{num: i + 1, indent: len(raw) - len(text)}
+		if strings.HasPrefix(text, "\t") {
+			return p.errorf(line, "tabs are not allowed in indentation")
+		}
+		line.text = strings.TrimRight(stripComment(text), " \t")
+
+		switch {
+		case line.text == "":
+			continue
+		case line.indent == 0 && line.text == "---":
+			if len(p.lines) > 0 {
+				return p.errorf(line, "only one document is supported")
+			}
+			continue
+		case line.indent == 0 && line.text == "...":
+			return nil
+		case line.indent == 0 && strings.HasPrefix(line.text, "%"):
+			return p.errorf(line, "directives are not supported")
+		}
+		p.lines = append(p.lines, line)
+	}
+	return nil
+}
+
+// stripComment removes a # comment, which starts a line or follows a space,
+// unless it is inside a quoted scalar.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[{,", s[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the mapping or sequence whose lines start at indent.
+func (p *yamlParser) block(indent int) (any, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// nested parses the block indented under the line just read, or returns nil
+// if there is none.
+func (p *yamlParser) nested(parent int) (any, error) {
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > parent {
+		return p.block(p.lines[p.pos].indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	items := []any{}
+	for p.pos < len(p.lines) {
+		line := &p.lines[p.pos]
+		if line.indent < indent || !isSequenceItem(line.text) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf(*line, "unexpected indentation")
+		}
+
+		var item any
+		var err error
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if _, _, ok, _ := splitKey(rest); ok || isSequenceItem(rest) {
+			// A block starting on the dash line: parse it as if the dash
+			// were indentation
+			line.indent += len(line.text) - len(rest)
+			line.text = rest
+			item, err = p.block(line.indent)
+		} else {
+			p.pos++
+			if rest == "" {
+				item, err = p.nested(indent)
+			} else {
+				item, err = p.scalar(*line, rest)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf(line, "unexpected indentation")
+		}
+		key, value, ok, err := splitKey(line.text)
+		if err != nil {
+			return nil, p.errorf(line, "%v", err)
+		}
+		if !ok {
+			return nil, p.errorf(line, "expected \"key: value\", found %q", line.text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf(line, "duplicate key %q", key)
+		}
+		p.pos++
+
+		var v any
+		switch {
+		case value != "":
+			v, err = p.scalar(line, value)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text):
+			// A sequence may sit at the same indentation as its key
+			v, err = p.sequence(indent)
+		default:
+			v, err = p.nested(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// splitKey splits "key: value" into its parts. The key may be quoted; the
+// colon must be followed by a space or end the line.
+func splitKey(text string) (key, value string, ok bool, err error) {
+	if text == "" || text[0] == '[' || text[0] == '{' || isSequenceItem(text) {
+		return "", "", false, nil
+	}
+
+	rest := text
+	if text[0] == '"' || text[0] == '\'' {
+		f := &flowParser{s: text}
+		if key, err = f.quoted(); err != nil {
+			return "", "", false, err
+		}
+		rest = strings.TrimLeft(text[f.pos:], " ")
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false, nil
+		}
+		rest = rest[1:]
+	} else {
+		i := indexMappingColon(text)
+		if i < 0 {
+			return "", "", false, nil
+		}
+		key, rest = strings.TrimRight(text[:i], " "), text[i+1:]
+	}
+	if rest != "" && rest[0] != ' ' {
+		return "", "", false, nil
+	}
+	return key, strings.TrimSpace(rest), true, nil
+}
+
+// indexMappingColon returns the index of the first colon followed by a space
+// or the end of text, or -1.
+func indexMappingColon(text string) int {
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+// scalar parses the value after a key or dash. Plain scalars run to the end
+// of the line; quoted ones and flow collections must end it.
+func (p *yamlParser) scalar(line yamlLine, s string) (any, error) {
+	switch s[0] {
+	case '&', '*', '!', '|', '>', '?', '@', '`':
+		return nil, p.errorf(line, "%q: anchors, aliases, tags and multi-line strings are not supported", s)
+	case '[', '{', '"', '\'':
+		f := &flowParser{s: s}
+		v, err := f.value()
+		if err == nil {
+			f.skipSpace()
+			if f.pos < len(s) {
+				err = fmt.Errorf("unexpected %q after the value", s[f.pos:])
+			}
+		}
+		if err != nil {
+			return nil, p.errorf(line, "%v", err)
+		}
+		return v, nil
+	}
+	return plainScalar(s), nil
+}
+
+// plainScalar resolves an unquoted scalar to null, a bool, a number or a
+// string, as YAML's core schema does.
+func plainScalar(s string) any {
+	switch s {
+	case "null", "Null", "NULL", "~":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if (s[0] == '-' || s[0] >= '0' && s[0] <= '9') && json.Valid([]byte(s)) {
+		return json.Number(s)
+	}
+	return s
+}
+
+// flowParser reads a flow collection or quoted scalar, such as
+// [1, "two", {three: 3}].
+type flowParser struct {
+	s   string
+	pos int
+}
+
+func (f *flowParser) skipSpace() {
+	for f.pos < len(f.s) && (f.s[f.pos] == ' ' || f.s[f.pos] == '\t') {
+		f.pos++
+	}
+}
+
+// next reports the next non-space byte, or 0 at the end.
+func (f *flowParser) next() byte {
+	f.skipSpace()
+	if f.pos == len(f.s) {
+		return 0
+	}
+	return f.s[f.pos]
+}
+
+func (f *flowParser) value() (any, error) {
+	switch f.next() {
+	case '[':
+		f.pos++
+		items := []any{}
+		for {
+			if f.next() == ']' {
+				f.pos++
+				return items, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+
+	case '{':
+		f.pos++
+		m := make(map[string]any)
+		for {
+			if f.next() == '}' {
+				f.pos++
+				return m, nil
+			}
+			key, err := f.key()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := m[key]; dup {
+				return nil, fmt.Errorf("duplicate key %q", key)
+			}
+			if m[key], err = f.value(); err != nil {
+				return nil, err
+			}
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+
+	case '"', '\'':
+		return f.quoted()
+
+	case 0, ',', ']', '}':
+		return nil, errors.New("missing value")
+	}
+	return plainScalar(f.plain()), nil
+}
+
+// separator consumes the comma between items, leaving a closing bracket for
+// the caller to read on its next turn.
+func (f *flowParser) separator(end byte) error {
+	switch f.next() {
+	case ',':
+		f.pos++
+		return nil
+	case end:
+		return nil
+	}
+	return fmt.Errorf("expected ',' or '%c' in flow collection", end)
+}
+
+// key reads a flow mapping key and the colon after it.
+func (f *flowParser) key() (string, error) {
+	var key string
+	switch f.next() {
+	case '"', '\'':
+		var err error
+		if key, err = f.quoted(); err != nil {
+			return "", err
+		}
+	default:
+		key = f.plain()
+	}
+	if key == "" || f.next() != ':' {
+		return "", errors.New("expected \"key: value\" in flow mapping")
+	}
+	f.pos++
+	return key, nil
+}
+
+// plain reads an unquoted scalar up to the next flow indicator.
+func (f *flowParser) plain() string {
+	start := f.pos
+	for ; f.pos < len(f.s); f.pos++ {
+		c := f.s[f.pos]
+		if c == ',' || c == ']' || c == '}' {
+			break
+		}
+		if c == ':' && (f.pos+1 == len(f.s) || strings.IndexByte(" ,]}", f.s[f.pos+1]) >= 0) {
+			break
+		}
+	}
+	return strings.TrimSpace(f.s[start:f.pos])
+}
+
+// quoted reads a single- or double-quoted scalar.
+func (f *flowParser) quoted() (string, error) {
+	quote := f.s[f.pos]
+	start := f.pos
+	for i := start + 1; i < len(f.s); i++ {
+		switch c := f.s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(f.s) && f.s[i+1] == '\'':
+			i++
+		case c == quote:
+			f.pos = i + 1
+			if quote == '\'' {
+				return strings.ReplaceAll(f.s[start+1:i], "''", "'"), nil
+			}
+			var s string
+			if err := json.Unmarshal([]byte(f.s[start:i+1]), &s); err != nil {
+				return "", fmt.Errorf("invalid double-quoted string %s", f.s[start:i+1])
+			}
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unterminated %c-quoted string", quote)
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Map applies fn to every value of in, one at a time, in order.
+func Map[In, Out any](ctx context.Context, in <-chan In, fn func(context.Context, In) Out) <-chan Out {
+	out := make(chan Out)
+
+	go func() {
+		defer close(out)
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				return
+			}
+			start := time.Now()
+			result := fn(ctx, v)
+			observe(ctx, start)
+			if !send(ctx, out, result) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// FanOut starts n workers that all read from in, so each value is handled by
+// exactly one of them. fn receives the worker ID, from 1 to n. Every worker
+// has its own output channel; join them with Merge.
+func FanOut[In, Out any](ctx context.Context, in <-chan In, n int, fn func(ctx context.Context, worker int, v In) Out) []<-chan Out {
+	outs := make([]<-chan Out, n)
+	for i := 0; i < n; i++ {
+		id := i + 1
+		outs[i] = Map(ctx, in, func(ctx context.Context, v In) Out {
+			return fn(ctx, id, v)
+		})
+	}
+	return outs
+}
+
+// Merge fans in several channels into one. The output is closed once every
+// input is closed or ctx is cancelled. Values from one input keep their
+// order; values from different inputs are interleaved as they arrive.
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		go func(in <-chan T) {
+			defer wg.Done()
+			for {
+				v, ok := receive(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}(in)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
@@ -0,0 +1,323 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Window is a half-open interval of event time, [Start, End).
+type Window struct {
+	Start, End time.Time
+}
+
+type windowKind int
+
+const (
+	tumbling windowKind = iota
+	sliding
+	session
+)
+
+// WindowSpec describes how values are grouped into windows.
+type WindowSpec struct {
+	kind  windowKind
+	size  time.Duration
+	slide time.Duration
+	gap   time.Duration
+}
+
+// Tumbling windows are fixed-size and do not overlap. It panics if size is
+// not positive.
+func Tumbling(size time.Duration) WindowSpec {
+	mustBePositive("Tumbling", "size", size)
+	return WindowSpec{kind: tumbling, size: size, slide: size}
+}
+
+// Sliding windows are size long and start every slide, so a value belongs to
+// size/slide windows. It panics if size or slide is not positive.
+func Sliding(size, slide time.Duration) WindowSpec {
+	mustBePositive("Sliding", "size", size)
+	mustBePositive("Sliding", "slide", slide)
+	return WindowSpec{kind: sliding, size: size, slide: slide}
+}
+
+// Session windows group values of a key separated by less than gap; a session
+// closes after gap without values. It panics if gap is not positive.
+func Session(gap time.Duration) WindowSpec {
+	mustBePositive("Session", "gap", gap)
+	return WindowSpec{kind: session, gap: gap}
+}
+
+// mustBePositive panics on a window duration that would make assign loop
+// forever or assign no windows at all.
+func mustBePositive(fn, name string, d time.Duration) {
+	if d <= 0 {
+		panic(fmt.Sprintf("pipeline: %s window %s must be positive, got %v", fn, name, d))
+	}
+}
+
+// assign returns the windows containing event time t.
+func (s WindowSpec) assign(t time.Time) []Window {
+	switch s.kind {
+	case session:
+		return []Window{{Start: t, End: t.Add(s.gap)}}
+	default:
+		// The latest window starting at or before t, then earlier overlapping ones
+		last := t.Truncate(s.slide)
+		var windows []Window
+		for start := last; start.Add(s.size).After(t); start = start.Add(-s.slide) {
+			windows = append(windows, Window{Start: start, End: start.Add(s.size)})
+		}
+		return windows
+	}
+}
+
+// Number is any built-in integer or floating point type.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Aggregator folds the values of a window into an accumulator.
+type Aggregator[T, A any] struct {
+	Init func() A
+	Add  func(acc A, v T) A
+	// First, if set, starts the accumulator from a window's first value
+	// instead of Add(Init(), v).
+	First func(v T) A
+	// Merge combines two accumulators; only session windows need it.
+	Merge func(a, b A) A
+}
+
+func (agg Aggregator[T, A]) add(acc A, n int, v T) A {
+	if n == 0 {
+		if agg.First != nil {
+			return agg.First(v)
+		}
+		acc = agg.Init()
+	}
+	return agg.Add(acc, v)
+}
+
+// Count counts the values of each window.
+func Count[T any]() Aggregator[T, int] {
+	return Aggregator[T, int]{
+		Init:  func() int { return 0 },
+		Add:   func(acc int, _ T) int { return acc + 1 },
+		Merge: func(a, b int) int { return a + b },
+	}
+}
+
+// Sum adds up value(v) over each window.
+func Sum[T any, N Number](value func(T) N) Aggregator[T, N] {
+	return Aggregator[T, N]{
+		Init:  func() N { return 0 },
+		Add:   func(acc N, v T) N { return acc + value(v) },
+		Merge: func(a, b N) N { return a + b },
+	}
+}
+
+// Min keeps the smallest value(v) of each window.
+func Min[T any, N Number](value func(T) N) Aggregator[T, N] {
+	return Aggregator[T, N]{
+		First: value,
+		Add:   func(acc N, v T) N { return min(acc, value(v)) },
+		Merge: func(a, b N) N { return min(a, b) },
+	}
+}
+
+// Max keeps the largest value(v) of each window.
+func Max[T any, N Number](value func(T) N) Aggregator[T, N] {
+	return Aggregator[T, N]{
+		First: value,
+		Add:   func(acc N, v T) N { return max(acc, value(v)) },
+		Merge: func(a, b N) N { return max(a, b) },
+	}
+}
+
+// WindowConfig tells Aggregate how to window a stream of T.
+type WindowConfig[T any] struct {
+	Spec WindowSpec
+	// Key partitions the stream; every key has its own windows. Nil puts
+	// everything under the empty key.
+	Key func(T) string
+	// EventTime is when the value happened, which decides its windows.
+	EventTime func(T) time.Time
+	// MaxOutOfOrder is how far behind the latest event time a value may
+	// arrive. The watermark, the point up to which the stream is considered
+	// complete, trails the latest event time by this much.
+	MaxOutOfOrder time.Duration
+	// AllowedLateness keeps a window open after it has fired, so values that
+	// arrive behind the watermark still update it.
+	AllowedLateness time.Duration
+	// OnDropped, if set, is called for values too late for every window.
+	OnDropped func(T)
+}
+
+// WindowResult is the aggregate of one key's window.
+type WindowResult[A any] struct {
+	Key    string
+	Window Window
+	Value  A
+	Count  int  // Values in the window
+	Late   bool // An update of a window that already fired, caused by a late value
+}
+
+type windowState[A any] struct {
+	acc   A
+	count int
+	fired bool
+}
+
+// Aggregate assigns values to event-time windows per key and emits each
+// window's aggregate when the watermark passes its end. A window stays open
+// for AllowedLateness more, re-emitting an updated result (Late set) for each
+// late value, and is then discarded. Every open window is emitted when in is
+// closed.
+func Aggregate[T, A any](ctx context.Context, in <-chan T, cfg WindowConfig[T], agg Aggregator[T, A]) <-chan WindowResult[A] {
+	out := make(chan WindowResult[A])
+	key := cfg.Key
+	if key == nil {
+		key = func(T) string { return "" }
+	}
+
+	go func() {
+		defer close(out)
+
+		state := make(map[string]map[Window]*windowState[A])
+		var watermark time.Time
+		var maxEventTime time.Time
+
+		// emit fires and purges windows by the watermark; final fires everything
+		emit := func(final bool) bool {
+			var results []WindowResult[A]
+			for k, windows := range state {
+				for w, st := range windows {
+					if !final && w.End.After(watermark) {
+						continue
+					}
+					if !st.fired {
+						results = append(results, WindowResult[A]{Key: k, Window: w, Value: st.acc, Count: st.count})
+						st.fired = true
+					}
+					if final || !w.End.Add(cfg.AllowedLateness).After(watermark) {
+						delete(windows, w)
+					}
+				}
+				if len(windows) == 0 {
+					delete(state, k)
+				}
+			}
+			sort.Slice(results, func(i, j int) bool {
+				if !results[i].Window.End.Equal(results[j].Window.End) {
+					return results[i].Window.End.Before(results[j].Window.End)
+				}
+				return results[i].Key < results[j].Key
+			})
+			for _, r := range results {
+				if !send(ctx, out, r) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				if ctx.Err() == nil {
+					emit(true)
+				}
+				return
+			}
+			start := time.Now()
+
+			t := cfg.EventTime(v)
+			k := key(v)
+			if t.After(maxEventTime) {
+				maxEventTime = t
+				watermark = maxEventTime.Add(-cfg.MaxOutOfOrder)
+			}
+
+			windows := state[k]
+			if windows == nil {
+				windows = make(map[Window]*windowState[A])
+				state[k] = windows
+			}
+
+			accepted := false
+			var updates []WindowResult[A]
+			for _, w := range cfg.Spec.assign(t) {
+				if !w.End.Add(cfg.AllowedLateness).After(watermark) {
+					continue // Past allowed lateness
+				}
+				if cfg.Spec.kind == session {
+					w = mergeSessions(windows, w, agg)
+				}
+				st := windows[w]
+				if st == nil {
+					st = &windowState[A]{}
+					windows[w] = st
+				}
+				st.acc = agg.add(st.acc, st.count, v)
+				st.count++
+				accepted = true
+				if st.fired {
+					updates = append(updates, WindowResult[A]{Key: k, Window: w, Value: st.acc, Count: st.count, Late: true})
+				}
+			}
+			if !accepted && cfg.OnDropped != nil {
+				cfg.OnDropped(v)
+			}
+			if len(windows) == 0 {
+				delete(state, k)
+			}
+			observe(ctx, start)
+
+			for _, r := range updates {
+				if !send(ctx, out, r) {
+					return
+				}
+			}
+			if !emit(false) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// mergeSessions folds every session of a key overlapping w into one window
+// covering them all, and returns it.
+func mergeSessions[A, T any](windows map[Window]*windowState[A], w Window, agg Aggregator[T, A]) Window {
+	merged := w
+	var parts []*windowState[A]
+	for other, st := range windows {
+		if other.Start.Before(w.End) && w.Start.Before(other.End) {
+			if other.Start.Before(merged.Start) {
+				merged.Start = other.Start
+			}
+			if other.End.After(merged.End) {
+				merged.End = other.End
+			}
+			parts = append(parts, st)
+			delete(windows, other)
+		}
+	}
+	if len(parts) == 0 {
+		return merged
+	}
+
+	combined := parts[0]
+	for _, st := range parts[1:] {
+		combined.acc = agg.Merge(combined.acc, st.acc)
+		combined.count += st.count
+		combined.fired = combined.fired || st.fired
+	}
+	windows[merged] = combined
+	return merged
+}
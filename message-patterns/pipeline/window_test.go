@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWindowSpecRejectsNonPositiveDurations(t *testing.T) {
+	cases := []struct {
+		name string
+		spec func() WindowSpec
+	}{
+		{"tumbling zero size", func() WindowSpec { return Tumbling(0) }},
+		{"tumbling negative size", func() WindowSpec { return Tumbling(-time.Second) }},
+		{"sliding zero size", func() WindowSpec { return Sliding(0, time.Second) }},
+		{"sliding zero slide", func() WindowSpec { return Sliding(time.Second, 0) }},
+		{"sliding negative slide", func() WindowSpec { return Sliding(time.Second, -time.Second) }},
+		{"session zero gap", func() WindowSpec { return Session(0) }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				msg, _ := recover().(string)
+				if !strings.Contains(msg, "must be positive") {
+					t.Fatalf("recovered %q, want a must be positive panic", msg)
+				}
+			}()
+			c.spec()
+		})
+	}
+}
+
+func TestSlidingAssign(t *testing.T) {
+	at := time.Unix(100, 0)
+	windows := Sliding(3*time.Second, time.Second).assign(at.Add(500 * time.Millisecond))
+	if len(windows) != 3 {
+		t.Fatalf("got %d windows, want 3: %v", len(windows), windows)
+	}
+	for i, w := range windows {
+		if want := at.Add(-time.Duration(i) * time.Second); !w.Start.Equal(want) || w.End.Sub(w.Start) != 3*time.Second {
+			t.Fatalf("window %d = %v, want a 3s window from %v", i, w, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline/remote"
+)
+
+// Job and Result must match the types used by remote-worker.go
+type Job struct {
+	ID    int
+	Value int
+}
+
+type Result struct {
+	Worker string
+	JobID  int
+	Output int
+}
+
+func main() {
+	addr := flag.String("listen", "localhost:7070", "address workers connect to")
+	total := flag.Int("jobs", 100, "number of jobs to distribute")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Waiting for workers on %s (go run message-patterns/remote-worker.go -addr %s)\n", ln.Addr(), ln.Addr())
+
+	jobs := pipeline.Source(ctx, func(i int) (Job, bool) {
+		return Job{ID: i + 1, Value: i + 1}, i < *total
+	})
+
+	coordinator := remote.Serve[Job, Result](ctx, ln, jobs, remote.CoordinatorConfig{
+		OnJoin: func(worker string) {
+			fmt.Printf("Worker %s joined\n", worker)
+		},
+		OnLeave: func(worker string, reassigned int, err error) {
+			fmt.Printf("Worker %s left (%v), reassigning %d jobs\n", worker, err, reassigned)
+		},
+	})
+
+	done, failed := 0, 0
+	for outcome := range coordinator.Out() {
+		if outcome.Err != nil {
+			failed++
+			fmt.Println("Error:", outcome.Err)
+			continue
+		}
+		done++
+		r := outcome.Value
+		fmt.Printf("Worker %s: job %d -> %d\n", r.Worker, r.JobID, r.Output)
+	}
+	fmt.Printf("Finished: %d results, %d errors\n", done, failed)
+}
@@ -0,0 +1,69 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline/remote"
+)
+
+// Job and Result must match the types used by remote-coordinator.go
+type Job struct {
+	ID    int
+	Value int
+}
+
+type Result struct {
+	Worker string
+	JobID  int
+	Output int
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:7070", "coordinator address")
+	name := flag.String("name", "", "worker name, defaults to the local address")
+	credits := flag.Int("credits", 3, "jobs to run at once")
+	crashAfter := flag.Int("crash-after", 0, "exit abruptly after this many jobs, to watch them get reassigned")
+	flag.Parse()
+
+	if *name == "" {
+		host, _ := os.Hostname()
+		*name = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var started atomic.Int64
+	err := remote.Work(ctx, *addr, remote.WorkerConfig{Name: *name, Credits: *credits},
+		func(ctx context.Context, job Job) (Result, error) {
+			if n := started.Add(1); *crashAfter > 0 && n > int64(*crashAfter) {
+				fmt.Println("Crashing with jobs in flight")
+				os.Exit(1)
+			}
+			select {
+			case <-ctx.Done():
+				return Result{}, ctx.Err()
+			case <-time.After(time.Duration(100+rand.Intn(200)) * time.Millisecond):
+			}
+			if job.Value%25 == 0 {
+				return Result{}, errors.New("value is unprocessable")
+			}
+			fmt.Printf("Processed job %d\n", job.ID)
+			return Result{Worker: *name, JobID: job.ID, Output: job.Value * job.Value}, nil
+		})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+	fmt.Println("Coordinator finished, exiting")
+}
@@ -0,0 +1,80 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// Quote is a price offered by one pricing backend
+type Quote struct {
+	Provider string
+	Price    int
+}
+
+// pricing simulates a backend with its own latency, which sometimes fails
+func pricing(name string, latency time.Duration) pipeline.Backend[string, Quote] {
+	return pipeline.Backend[string, Quote]{
+		Name: name,
+		Call: func(ctx context.Context, item string) (Quote, error) {
+			jitter := time.Duration(rand.Int63n(int64(latency)))
+			select {
+			case <-ctx.Done():
+				return Quote{}, ctx.Err()
+			case <-time.After(latency + jitter):
+			}
+			if rand.Intn(5) == 0 {
+				return Quote{}, errors.New("service unavailable")
+			}
+			return Quote{Provider: name, Price: 90 + rand.Intn(20)}, nil
+		},
+	}
+}
+
+func main() {
+	backends := []pipeline.Backend[string, Quote]{
+		pricing("fast", 20*time.Millisecond),
+		pricing("steady", 60*time.Millisecond),
+		pricing("busy", 150*time.Millisecond),
+		pricing("remote", 300*time.Millisecond),
+		pricing("legacy", 800*time.Millisecond),
+	}
+
+	policies := []struct {
+		name   string
+		policy pipeline.GatherPolicy
+	}{
+		{"first", pipeline.GatherFirst},
+		{"first 2", pipeline.GatherFirstK(2)},
+		{"quorum", pipeline.GatherQuorum},
+		{"all within 400ms", pipeline.GatherAll(400 * time.Millisecond)},
+	}
+
+	for _, p := range policies {
+		start := time.Now()
+		result, err := pipeline.ScatterGather(context.Background(), "widget", p.policy, backends...)
+		fmt.Printf("Policy %s, after %v:\n", p.name, time.Since(start).Round(time.Millisecond))
+
+		for _, r := range result.Responses {
+			fmt.Printf("  %-7s quoted %d in %v\n", r.Backend, r.Value.Price, r.Latency.Round(time.Millisecond))
+		}
+		names := make([]string, 0, len(result.Errors))
+		for name := range result.Errors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %-7s no quote: %v\n", name, result.Errors[name])
+		}
+		if err != nil {
+			fmt.Println("  Incomplete:", err)
+		}
+	}
+}
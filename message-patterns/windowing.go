@@ -0,0 +1,62 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/quyenhl16/go-dspt/message-patterns/pipeline"
+)
+
+// Event is a result stamped with the time its job was created
+type Event struct {
+	Host  string
+	At    time.Time
+	Bytes int
+}
+
+// events simulates results coming out of parallel workers: they are mostly
+// in time order, but a slow worker can deliver one up to 2s behind the others
+func events(ctx context.Context, start time.Time, total int) <-chan Event {
+	hosts := []string{"web-1", "web-2"}
+	return pipeline.Source(ctx, func(i int) (Event, bool) {
+		if i >= total {
+			return Event{}, false
+		}
+		at := start.Add(time.Duration(i) * 300 * time.Millisecond)
+		at = at.Add(-time.Duration(rand.Intn(2000)) * time.Millisecond)
+		return Event{Host: hosts[rand.Intn(len(hosts))], At: at, Bytes: rand.Intn(1000)}, true
+	})
+}
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Bytes per host in 5-second tumbling windows, tolerating 1s of disorder
+	// and updating windows for values up to 1s later still
+	dropped := 0
+	sums := pipeline.Aggregate(ctx, events(ctx, start, 60), pipeline.WindowConfig[Event]{
+		Spec:            pipeline.Tumbling(5 * time.Second),
+		Key:             func(e Event) string { return e.Host },
+		EventTime:       func(e Event) time.Time { return e.At },
+		MaxOutOfOrder:   time.Second,
+		AllowedLateness: time.Second,
+		OnDropped:       func(Event) { dropped++ },
+	}, pipeline.Sum(func(e Event) int { return e.Bytes }))
+
+	for r := range sums {
+		late := ""
+		if r.Late {
+			late = " (late update)"
+		}
+		fmt.Printf("%s [%s, %s): %d bytes in %d events%s\n",
+			r.Key, r.Window.Start.Format("15:04:05"), r.Window.End.Format("15:04:05"), r.Value, r.Count, late)
+	}
+	fmt.Printf("%d events arrived too late to count\n", dropped)
+}